@@ -0,0 +1,118 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2017 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/01        Feng Yifei
+ */
+
+/**
+ * 简介：
+ *     该文件为 cron 表达式的说明工具：使用 cron.Parse 解析表达式，输出中英文说明、
+ *     常见错误的警告以及指定时区中接下来的执行时间
+ *
+ *     go run cronexplain.go -n 5 -tz Asia/Shanghai "* *\/5 * * * *"
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TechCatsLab/gosnippet/samples/productions/jobs"
+)
+
+var (
+	runs   = flag.Int("n", 5, "列出接下来的执行次数")
+	zone   = flag.String("tz", "Local", "执行时间使用的时区，例如 Asia/Shanghai、UTC")
+	from   = flag.String("from", "", "从该时间（RFC 3339 格式）开始计算，为空时使用当前时间")
+	strict = flag.Bool("strict", false, "有警告时以状态码 2 退出，可用于检查配置")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: cronexplain [flags] <spec>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	//cron.Parse在返回错误之前会通过log输出同样的信息
+	log.SetOutput(ioutil.Discard)
+
+	//表达式中包含空格，未加引号时由多个参数拼接
+	spec := strings.Join(flag.Args(), " ")
+	if spec == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	loc, err := time.LoadLocation(*zone)
+	if err != nil {
+		fmt.Println("Load time zone failed:", err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	if *from != "" {
+		if start, err = time.Parse(time.RFC3339, *from); err != nil {
+			fmt.Println("Parse -from failed:", err)
+			os.Exit(1)
+		}
+	}
+
+	ex, err := jobs.Explain(spec)
+	if err != nil {
+		fmt.Println("Invalid spec:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Spec:   ", ex.Spec)
+	fmt.Println("English:", ex.English)
+	fmt.Println("中文:   ", ex.Chinese)
+
+	for _, w := range ex.Warnings {
+		fmt.Println()
+		fmt.Println("Warning:", w.English)
+		fmt.Println("警告:   ", w.Chinese)
+		if w.Suggestion != "" {
+			fmt.Printf("Did you mean %q?\n", w.Suggestion)
+		}
+	}
+
+	next, _ := jobs.NextRuns(spec, start.In(loc), *runs)
+	fmt.Println()
+	fmt.Printf("Next %d runs (%s):\n", len(next), loc)
+	for _, t := range next {
+		fmt.Println("  ", t.Format("2006-01-02 15:04:05 Mon MST"))
+	}
+
+	if *strict && len(ex.Warnings) > 0 {
+		os.Exit(2)
+	}
+}
@@ -1,25 +1,157 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
-	"github.com/robfig/cron"
+	"github.com/TechCatsLab/gosnippet/samples/productions/jobs"
 )
 
+// admin 为管理接口的监听地址，可以通过 curl 查看任务状态、暂停、恢复或立即执行任务：
+//
+//	curl localhost:7070/jobs
+//	curl -X POST localhost:7070/jobs/print-time/trigger
+var admin = flag.String("admin", "localhost:7070", "管理接口的监听地址，为空时不启动")
+
+// state 记录各任务最后一次成功完成的触发时间，重启后据此补执行停机期间错过或失败的任务
+var state = flag.String("state", "cronjob.state", "状态文件路径，为空时不记录")
+
+// locks 锁文件所在的目录，滚动重启期间同一台机器上同时运行两个实例时，账单任务只会在其中一个上执行
+var locks = flag.String("locks", os.TempDir(), "锁文件目录，为空时不加锁")
+
+// drain 收到 SIGINT 或 SIGTERM 后等待正在进行的任务结束的最长时间，超时后取消任务并以状态 1 退出
+var drain = flag.Duration("drain", 30*time.Second, "退出时等待任务结束的最长时间")
+
+// grace 超过 drain 取消任务后，等待任务返回、写入状态文件的最长时间
+var grace = flag.Duration("grace", jobs.DefaultGrace, "取消任务后等待其返回的最长时间")
+
 func main() {
-	c := cron.New()
-	// cronTime := "* * * * * Mon,Wed" 表示星期一，星期三执行
+	flag.Parse()
+
+	m := jobs.New(jobs.DefaultHistory)
+	m.SetGrace(*grace)
+	if *state != "" {
+		if err := m.LoadState(*state); err != nil {
+			fmt.Println("Load state failed:", err)
+			return
+		}
+	}
+
+	// 第一个字段为秒，为 "*" 时匹配期间每秒都会执行，可以用 cronexplain.go 检查表达式
+	// cronTime := "0 0 0 * * Mon,Wed" 表示星期一，星期三的 0 点执行
 	// cronTime := "0 0 19 * * *" 每天 19:00 点执行一次
-	// cronTime := "* * 8-16 * * *" 表示 8am 到 4pm 整点执行（包括8和16）
-	cronTime := "* */5 * * * *" // 每五分钟执行一次
+	// cronTime := "0 0 8-16 * * *" 表示 8am 到 4pm 整点执行（包括8和16）
+	cronTime := "0 */5 * * * *" // 每五分钟执行一次
 
-	c.AddFunc(cronTime, func() {
-		// Do want you want
-		fmt.Println(time.Now())
+	// 上一次执行尚未结束时跳过本次，单次执行最多一分钟
+	err := m.Add(jobs.Job{
+		Name:    "print-time",
+		Spec:    cronTime,
+		Overlap: jobs.Skip,
+		Timeout: time.Minute,
+		Func: func(ctx context.Context) error {
+			// Do want you want
+			fmt.Println(time.Now())
+			return nil
+		},
 	})
-	c.Start()
+	if err != nil {
+		fmt.Println("Add job failed:", err)
+		return
+	}
+
+	// 账单任务按北京时间每天 19:00 执行，不受服务器时区影响；停机错过时启动后补执行一次，
+	// 已经执行过的不会因为重启而重复执行
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		fmt.Println("Load location failed:", err)
+		return
+	}
+	var lock string
+	if *locks != "" {
+		lock = filepath.Join(*locks, "daily-billing.lock")
+	}
+	err = m.Add(jobs.Job{
+		Name:     "daily-billing",
+		Spec:     "0 0 19 * * *",
+		Overlap:  jobs.Skip,
+		Location: shanghai,
+		Missed:   jobs.MissedOnce,
+		Lock:     lock,
+		Func: func(ctx context.Context) error {
+			fmt.Println("billing", time.Now().In(shanghai).Format("2006-01-02"))
+			return nil
+		},
+	})
+	if err != nil {
+		fmt.Println("Add job failed:", err)
+		return
+	}
+
+	// 在开始调度之前注册信号，避免启动过程中收到的信号被默认处理直接杀死进程
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	m.Start()
+
+	var (
+		server *http.Server
+		failed = make(chan error, 1)
+	)
+	if *admin != "" {
+		server = &http.Server{Addr: *admin, Handler: m.Handler()}
+		go func() {
+			fmt.Println("Admin API listening on", *admin)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				failed <- err
+			}
+		}()
+	}
+
+	code := 0
+	select {
+	case sig := <-signals:
+		fmt.Println("Received", sig, "draining jobs for at most", *drain)
+	case err := <-failed:
+		fmt.Println("Admin API failed:", err)
+		code = 1
+	}
+
+	// 停止调度，等待正在进行的任务结束；期间再次收到信号时立即取消
+	ctx, cancel := context.WithTimeout(context.Background(), *drain)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Println("Received", sig, "again, canceling jobs")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if server != nil {
+		server.Shutdown(ctx)
+	}
+	if err := m.Shutdown(ctx); err == jobs.ErrAbandoned {
+		fmt.Println("Drain not finished, canceled jobs not returned after", *grace)
+		code = 1
+	} else if err != nil {
+		fmt.Println("Drain not finished, jobs canceled:", err)
+		code = 1
+	} else {
+		fmt.Println("All jobs finished")
+	}
+	if err := m.StateErr(); err != nil {
+		fmt.Println("Save state failed:", err)
+	}
 
-	// 确保函数不会跳出
-	select {}
+	cancel()
+	os.Exit(code)
 }
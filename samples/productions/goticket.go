@@ -35,51 +35,110 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
 	"time"
+
+	"github.com/TechCatsLab/gosnippet/samples/productions/pipeline"
+	"github.com/TechCatsLab/gosnippet/samples/productions/pipeline/rules"
 )
 
 //定义员工数据结构
 type Person struct {
-	Name    string
-	Age     uint8
-	Address Addr
+	Name    string `json:"name"`
+	Age     uint8  `json:"age"`
+	Address Addr   `json:"address"`
+	//修改过该条信息的规则，按执行顺序排列
+	Rules []string `json:"rules,omitempty"`
+	//读取时分配的序号，即该条信息在来源中的位置
+	Seq uint64 `json:"-"`
 }
 
 //定义地址数据结构
 type Addr struct {
-	city     string
-	district string
+	City     string `json:"city"`
+	District string `json:"district"`
 }
 
 //定义处理接口，方法Batch被声明为实现批量处理人员信息功能的方法，
 //其方法声明中的两个通道分别对该方法和该方法的调用方使用它的方式进行了约束
 type PersonHandler interface {
 	Batch(origs <-chan Person) <-chan Person
-	Handle(orig *Person)
+	Handle(orig *Person) error
+}
+
+//PersonHandlerImpl实现PersonHandler接口，Handle按engine中的规则处理人员信息
+type PersonHandlerImpl struct {
+	engine *rules.Engine
 }
 
-//定义空结构体，为其添加方法，实现PersonHandler接口
-type PersonHandlerImpl struct{}
+//handleWorkers为处理阶段并发执行Handle的goroutine数量
+//orderWindowSize为有序模式下重排缓冲区的大小
+const (
+	handleWorkers   = 4
+	orderWindowSize = 128
+)
 
+//有序模式下fecthPerson与Batch共享的重排窗口，非有序模式时为nil
+var orderWindow *pipeline.Window
+
+//Batch通过pipeline启动一个处理阶段，origs关闭且所有数据处理完成后，pipeline在发送方关闭dests
+//有序模式下dests中的人员信息与来源中的顺序一致
 func (handler PersonHandlerImpl) Batch(origs <-chan Person) <-chan Person {
-	dests := make(chan Person, 100)
-	go func() {
-		for p := range origs {
-			handler.Handle(&p)
-			dests <- p
+	stage := pipeline.Stage[Person, Person]{
+		Name:    "handle",
+		Workers: handleWorkers,
+		Buffer:  100,
+		Handle: func(p Person) (Person, error) {
+			err := handler.Handle(&p)
+			return p, err
+		},
+		Retry:   personRetry,
+		Errors:  deadLetter.C(),
+		Metrics: metrics.Stage("handle"),
+	}
+	if checkpoint != nil {
+		stage.Discard = func(p Person, err error) {
+			checkpoint.Commit(p.Seq)
 		}
-		fmt.Println("All the information has been handled.")
-		//在发送方关闭通道
-		close(dests)
-	}()
-	return dests
+	}
+	if orderWindow != nil {
+		stage.Seq = func(p Person) uint64 { return p.Seq }
+		stage.Window = orderWindow
+	}
+	return stage.Flow()(context.Background(), origs)
 }
 
-func (handler PersonHandlerImpl) Handle(orig *Person) {
-	if orig.Address.district == "Haidian" {
-		orig.Address.district = "Shijingshan"
+//Handle对orig应用规则，记录被规则丢弃时返回pipeline.ErrDrop
+func (handler PersonHandlerImpl) Handle(orig *Person) error {
+	result, err := handler.engine.Apply(orig)
+	if err != nil {
+		return err
 	}
+
+	orig.Rules = append(orig.Rules, result.Applied...)
+	if result.Dropped {
+		return pipeline.ErrDrop
+	}
+	return nil
+}
+
+//defaultRules为未指定规则文件时使用的规则，将海淀区统一改为石景山区
+var defaultRules = []rules.Rule{
+	{
+		Name:  "haidian",
+		When:  []rules.Condition{{Field: "address.district", Eq: "Haidian"}},
+		Then:  []rules.Action{{Type: rules.ActionSet, Field: "address.district", Value: "Shijingshan"}},
+		Final: true,
+	},
 }
 
 //定义要被处理的数据并初始化
@@ -89,10 +148,54 @@ var persons []Person = make([]Person, personTotal)
 
 var personCount int
 
+//人员信息的来源与去向，由命令行参数决定，默认读取persons，保存在内存中
+var (
+	input   = flag.String("in", "", "输入路径：.csv、.jsonl 文件或 JSON 文件目录，为空时使用内置数据")
+	output  = flag.String("out", "", "输出路径：.csv、.jsonl 文件或目录，为空时保存在内存中")
+	rule    = flag.String("rules", "", "JSON 格式的规则文件，为空时使用 defaultRules")
+	ordered = flag.Bool("ordered", false, "按来源中的顺序保存处理后的人员信息")
+	dead    = flag.String("dead", "", "死信文件，以 JSON Lines 格式记录处理或保存失败的人员信息、错误及阶段，为空时不记录")
+	retries = flag.Int("retries", 3, "单条人员信息处理或保存失败时的最大尝试次数")
+	ckpt    = flag.String("checkpoint", "", "检查点文件，定期记录已提交的来源偏移量，重启后从该偏移量继续，为空时不记录")
+	ckptGap = flag.Duration("checkpoint-interval", 5*time.Second, "写入检查点文件的间隔")
+	every   = flag.Duration("progress", time.Second, "在标准错误输出中刷新各阶段进度的间隔，为 0 时不输出")
+
+	source pipeline.Source[Person]
+	sink   pipeline.Sink[Person]
+
+	//处理与保存失败时的重试策略，以及接收最终失败的人员信息的死信队列
+	personRetry pipeline.Retry
+	deadLetter  *pipeline.DeadLetter
+
+	//检查点，未指定检查点文件时为nil
+	checkpoint *pipeline.Checkpoint
+
+	//fetch、handle、save三个阶段的统计数据
+	metrics = pipeline.NewMetrics()
+
+	//批处理中的等待、超时与计时使用的时钟，测试时可以替换为pipeline.FakeClock
+	clock pipeline.Clock = pipeline.SystemClock
+)
+
+//personCSV定义Person与CSV行之间的转换
+var personCSV = pipeline.CSVCodec[Person]{
+	Header: []string{"name", "age", "city", "district"},
+	Encode: func(p Person) ([]string, error) {
+		return []string{p.Name, strconv.Itoa(int(p.Age)), p.Address.City, p.Address.District}, nil
+	},
+	Decode: func(row []string) (Person, error) {
+		age, err := strconv.ParseUint(row[1], 10, 8)
+		if err != nil {
+			return Person{}, err
+		}
+		return Person{Name: row[0], Age: uint8(age), Address: Addr{row[2], row[3]}}, nil
+	},
+}
+
 func init() {
 	for i := 0; i < 200; i++ {
 		name := fmt.Sprintf("%s%d", "P", i)
-		p := Person{name, 32, Addr{"Beijing", "Haidian"}}
+		p := Person{Name: name, Age: 32, Address: Addr{"Beijing", "Haidian"}}
 		persons[i] = p
 	}
 }
@@ -100,122 +203,328 @@ func init() {
 //main函数中首先获取handler，初始化origs通道，将人员信息通过origs通道传入
 //Batch中处理，处理后的信息放入dests通道中，并将dests通道返回。
 //通道初始化完成后，fecthPerson获取人员信息放入到origs中，savePerson从dests中接收处理过的信息进行保存
-//其中sign通道作用为在批处理完全执行结束之前阻塞主Goroutine
+//其中sign通道作用为在批处理完全执行结束之前阻塞主Goroutine，并返回保存的统计结果
 func main() {
-	handler := getPersonHandler()
+	flag.Parse()
+
+	var err error
+	if source, err = openSource(*input); err != nil {
+		fmt.Println("Open source failed:", err)
+		os.Exit(1)
+	}
+	defer source.Close()
+
+	//从检查点恢复时跳过已提交的人员信息，序号从检查点的偏移量继续分配
+	var offset uint64
+	if *ckpt != "" {
+		if offset, err = pipeline.LoadCheckpoint(*ckpt); err != nil {
+			fmt.Println("Load checkpoint failed:", err)
+			os.Exit(1)
+		}
+		if err = pipeline.Skip(source, offset); err != nil {
+			fmt.Println("Skip committed persons failed:", err)
+			os.Exit(1)
+		}
+		if offset > 0 {
+			fmt.Println("Resume from checkpoint, offset:", offset)
+		}
+		personCount = int(offset)
+	}
+
+	//恢复时追加到之前的输出与死信文件之后，已提交的人员信息不会被覆盖
+	resume := offset > 0
+	if sink, err = openSink(*output, resume); err != nil {
+		fmt.Println("Open sink failed:", err)
+		os.Exit(1)
+	}
+	defer sink.Close()
+
+	deadFile := ioutil.Discard
+	if *dead != "" {
+		file, err := openOutput(*dead, resume)
+		if err != nil {
+			fmt.Println("Open dead letter failed:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		deadFile = file
+	}
+	deadLetter = pipeline.NewDeadLetter(deadFile, 100)
+
+	//写入检查点之前先刷新sink与死信队列，保证已提交的人员信息确实已经保存或写入死信
+	if *ckpt != "" {
+		checkpoint = pipeline.NewCheckpoint(*ckpt, offset, func() error {
+			if err := sink.Flush(); err != nil {
+				return err
+			}
+			return deadLetter.Flush()
+		})
+		checkpoint.SetClock(clock)
+		savePolicy.Done = func(record interface{}, err error) {
+			checkpoint.Commit(record.(Person).Seq)
+		}
+	}
+
+	if *ordered {
+		orderWindow = pipeline.NewWindowAt(orderWindowSize, offset)
+	}
+
+	//按fetch、handle、save的顺序输出各阶段的进度
+	metrics.SetClock(clock)
+	metrics.Stage("fetch")
+	metrics.Stage("handle")
+	metrics.Stage("save")
+
+	personRetry = pipeline.Retry{Attempts: *retries, Backoff: 10 * time.Millisecond, MaxBackoff: time.Second, Clock: clock}
+	savePolicy.Retry = personRetry
+	savePolicy.Clock = clock
+	savePolicy.Errors = deadLetter.C()
+	savePolicy.Metrics = metrics.Stage("save")
+
+	handler, err := getPersonHandler(*rule)
+	if err != nil {
+		fmt.Println("Load rules failed:", err)
+		os.Exit(1)
+	}
+	//收到中断信号时停止读取与保存，保存检查点后退出，重启后从检查点继续
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	//保存因中断或超时提前结束时同样停止读取，以便各阶段排空后关闭死信队列
+	fetchCtx, fetchCancel := context.WithCancel(ctx)
+	defer fetchCancel()
+
 	origs := make(chan Person, 100)
 	dests := handler.Batch(origs)
-	fecthPerson(origs)
-	sign := savePerson(dests)
-	<-sign
+	fecthPerson(fetchCtx, origs)
+
+	ckptCtx, ckptCancel := context.WithCancel(context.Background())
+	ckptDone := make(chan error, 1)
+	if checkpoint != nil {
+		go func() {
+			ckptDone <- checkpoint.Run(ckptCtx, *ckptGap)
+		}()
+	}
+
+	progressCtx, progressCancel := context.WithCancel(context.Background())
+	progressDone := make(chan struct{})
+	if *every > 0 {
+		go func() {
+			metrics.Progress(progressCtx, os.Stderr, *every)
+			close(progressDone)
+		}()
+	} else {
+		close(progressDone)
+	}
+
+	sign := savePerson(ctx, dests)
+	report := <-sign
+
+	//提前结束时停止读取，丢弃各阶段中剩余的人员信息，这些人员信息没有提交，恢复后重新处理
+	if report.Reason != pipeline.StopClosed {
+		fetchCancel()
+		pipeline.Drain(dests)
+	}
+
+	//保存结束后输出各阶段最终的进度
+	progressCancel()
+	<-progressDone
+
+	//停止定期写入并保存最终的检查点，写入前会刷新sink
+	//批处理全部完成时删除检查点文件，下一次从头开始
+	ckptCancel()
+	if checkpoint != nil {
+		if err = <-ckptDone; err != nil {
+			fmt.Println("Save checkpoint failed:", err)
+		} else if report.Reason == pipeline.StopClosed && fetchErr == nil {
+			checkpoint.Remove()
+		} else {
+			fmt.Println("Checkpoint offset:", checkpoint.Offset())
+		}
+	}
+
+	//dests关闭后所有阶段都已退出，此时关闭死信队列，等待失败信息全部写入
+	if err := deadLetter.Close(); err != nil {
+		fmt.Println("Write dead letter failed:", err)
+	}
+	fmt.Println("Failed records:", deadLetter.Summary())
+}
+
+//openSource根据路径的扩展名选择来源，路径为目录时逐个读取其中的JSON文件
+func openSource(path string) (pipeline.Source[Person], error) {
+	if path == "" {
+		return pipeline.NewMemorySource(persons), nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return pipeline.NewDirSource[Person](path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".csv":
+		return pipeline.NewCSVSource(file, personCSV), nil
+	case ".jsonl":
+		return pipeline.NewJSONLinesSource[Person](file), nil
+	}
+
+	file.Close()
+	return nil, fmt.Errorf("unsupported input %s", path)
+}
+
+//openSink根据路径的扩展名选择去向，没有扩展名时作为目录，每条记录保存为一个JSON文件
+//目录中的文件名由序号和姓名组成，同名的人员不会相互覆盖
+//resume为true时追加到已有的文件之后，文件不为空时不再写入CSV表头
+func openSink(path string, resume bool) (pipeline.Sink[Person], error) {
+	switch filepath.Ext(path) {
+	case "":
+		if path == "" {
+			return pipeline.NewMemorySink[Person](), nil
+		}
+		return pipeline.NewDirSink(path, func(p Person) string { return fmt.Sprintf("%08d-%s", p.Seq, p.Name) })
+	case ".csv":
+		file, err := openOutput(path, resume)
+		if err != nil {
+			return nil, err
+		}
+		codec := personCSV
+		if info, err := file.Stat(); err == nil && info.Size() > 0 {
+			codec.Header = nil
+		}
+		return pipeline.NewCSVSink(file, codec), nil
+	case ".jsonl":
+		file, err := openOutput(path, resume)
+		if err != nil {
+			return nil, err
+		}
+		return pipeline.NewJSONLinesSink[Person](file), nil
+	}
+	return nil, fmt.Errorf("unsupported output %s", path)
 }
 
-func getPersonHandler() PersonHandler {
-	return PersonHandlerImpl{}
+//openOutput创建输出文件，resume为true时以追加方式打开，保留之前运行已写入的内容
+func openOutput(path string, resume bool) (*os.File, error) {
+	if !resume {
+		return os.Create(path)
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 }
 
-func savePerson(dest <-chan Person) <-chan byte {
-	sign := make(chan byte, 1)
+func getPersonHandler(path string) (PersonHandler, error) {
+	var (
+		engine *rules.Engine
+		err    error
+	)
 
-	go func() {
-		ok := true
-		var p Person
-		for {
-			select {
-			case p, ok = <-dest:
-				{
-					if !ok {
-						fmt.Println("All the information has been saved.")
-						sign <- 0
-						break
-					}
-					savePerson1(p)
-				}
-			case ok = <-func() chan bool {
-				timeout := make(chan bool, 1)
-				go func() {
-					time.Sleep(time.Millisecond)
-					timeout <- false
-				}()
-				return timeout
-			}():
-				fmt.Println("TimeOut!")
-				sign <- 0
-				break
-			}
+	if path == "" {
+		engine, err = rules.New(defaultRules...)
+	} else {
+		engine, err = rules.Load(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return PersonHandlerImpl{engine: engine}, nil
+}
 
-			if !ok {
-				break
-			}
+//savePolicy为savePerson的等待策略，连续一秒没有收到处理后的数据即认为超时
+var savePolicy = pipeline.IdleTimeout(time.Second)
+
+//savePerson保存dest中的人员信息，ctx结束时停止，结束后通过sign返回统计结果
+func savePerson(ctx context.Context, dest <-chan Person) <-chan pipeline.SinkReport {
+	sign := make(chan pipeline.SinkReport, 1)
+
+	go func() {
+		report := pipeline.Save(ctx, dest, savePerson1, savePolicy)
+		switch report.Reason {
+		case pipeline.StopClosed:
+			fmt.Println("All the information has been saved.", report)
+		case pipeline.StopCanceled:
+			fmt.Println("Interrupted!", report)
+		default:
+			fmt.Println("TimeOut!", report)
 		}
+		sign <- report
 	}()
 	return sign
 }
 
-func fecthPerson(origs chan<- Person) {
+//ctx结束时停止读取，等待已取出的人员信息发送完成后关闭origs
+func fecthPerson(ctx context.Context, origs chan<- Person) {
 	//调用cap函数确定origs是否为缓冲通道
 	origsCap := cap(origs)
 	buffered := origsCap > 0
 	//以origsCap的一半作为Goroutine票池的总数，创建票池
-	goTicketTotal := origsCap / 2
-	goTicket := initGoTicket(goTicketTotal)
+	goTicket := pipeline.NewTickets(origsCap / 2)
+	goTicket.SetClock(clock)
+	fetch := metrics.Stage("fetch")
+	fetch.WatchTickets(goTicket)
+	fetch.WatchQueue(func() int { return len(origs) })
 	go func() {
 		for {
-			p, ok := fecthPerson1()
+			//有序模式下，序号超出重排窗口时等待Batch发送完之前的人员信息；ctx结束时不再读取
+			p, ok := Person{}, false
+			if ctx.Err() == nil && (orderWindow == nil || orderWindow.Wait(ctx, uint64(personCount)) == nil) {
+				p, ok = fecthPerson1()
+			}
 			if !ok {
-				for {
-					//如果为非缓冲通道或者所有goroutine已完成工作，跳出循环
-					if !buffered || len(goTicket) == goTicketTotal {
-						break
-					}
-					time.Sleep(time.Nanosecond)
+				//阻塞等待所有goroutine归还票，非缓冲通道时票池始终是空闲的
+				goTicket.Wait(context.Background())
+				if ctx.Err() != nil {
+					fmt.Println("Fetching stopped.")
+				} else {
+					fmt.Println("All the information has been fetched.")
 				}
-				fmt.Println("All the information has been fetched.")
 				//在发送方关闭通道
 				close(origs)
 				break
 			}
 
-			//如果为缓冲通道，从goTicket接受一个值，表示有一个goroutine被占用
-			//当操作完成后，向其中发送一个值，表示接解除占用
+			//如果为缓冲通道，从goTicket获取一张票，表示有一个goroutine被占用
+			//当操作完成后归还，表示解除占用
 			if buffered {
-				<-goTicket
+				goTicket.Acquire(context.Background())
 				go func() {
 					origs <- p
-					goTicket <- 1
+					fetch.IncOut()
+					goTicket.Release()
 				}()
 			} else {
 				origs <- p
+				fetch.IncOut()
 			}
 		}
 	}()
 }
 
-//goTicket是为了限制该程序启用的goroutine的数量而声明的一个缓冲通道
-//根据传进来的total初始化通道，total即表示可以启用goroutine数量
-//每当启用一个goroutine时从该通道中接受一个值表示可用goroutine少了一个
-//即每个goroutine要想启动必须要有ticket。上述是在origs为缓冲条件下，即整个过程为异步完成情况下
-func initGoTicket(total int) chan byte {
-	var goTicket chan byte
-	if total == 0 {
-		return goTicket
-	}
-	goTicket = make(chan byte, total)
-	for i := 0; i < total; i++ {
-		goTicket <- 1
-	}
-	return goTicket
-}
+//fetchErr记录读取人员信息时遇到的错误，读取中断时不能删除检查点
+var fetchErr error
 
 func fecthPerson1() (Person, bool) {
-	if personCount < personTotal {
-		p := persons[personCount]
-		personCount++
-		return p, true
+	fetch := metrics.Stage("fetch")
+	start := clock.Now()
+	p, err := source.Read()
+	fetch.Observe(clock.Now().Sub(start))
+	if err != nil {
+		if err != io.EOF {
+			fmt.Println("Read person failed:", err)
+			fetchErr = err
+		}
+		return Person{}, false
 	}
-	return Person{}, false
+	fetch.IncIn()
+	p.Seq = uint64(personCount)
+	personCount++
+	return p, true
 }
 
-func savePerson1(p Person) bool {
-	return true
+func savePerson1(p Person) error {
+	return sink.Write(p)
 }
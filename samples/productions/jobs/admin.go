@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/31        Feng Yifei
+ */
+package jobs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Detail 单个任务的状态与执行历史
+type Detail struct {
+	Info
+	History []Record `json:"history"`
+}
+
+// Handler 返回以 JSON 格式查看与控制任务的 HTTP 管理接口：
+//
+//	GET  /jobs                 所有任务的状态
+//	GET  /jobs/{name}          任务的状态与执行历史
+//	POST /jobs/{name}/pause    暂停任务
+//	POST /jobs/{name}/resume   恢复任务
+//	POST /jobs/{name}/trigger  立即执行一次任务
+//
+// 任务名称中的特殊字符需要进行 URL 编码。挂载在其他路径下时使用 http.StripPrefix。
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(m.serveHTTP)
+}
+
+func (m *Manager) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if path == "/jobs" || path == "/jobs/" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, m.Jobs())
+		return
+	}
+
+	if !strings.HasPrefix(path, "/jobs/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/jobs/"), "/")
+	if len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name, err := url.PathUnescape(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		m.serveDetail(w, name)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	status := http.StatusOK
+	switch parts[1] {
+	case "pause":
+		err = m.Pause(name)
+	case "resume":
+		err = m.Resume(name)
+	case "trigger":
+		err = m.Trigger(name)
+		status = http.StatusAccepted
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case ErrSkipped:
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case ErrStopped:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	info, err := m.Job(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, status, info)
+}
+
+func (m *Manager) serveDetail(w http.ResponseWriter, name string) {
+	info, err := m.Job(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	history, err := m.History(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, Detail{Info: info, History: history})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
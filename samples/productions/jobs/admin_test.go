@@ -0,0 +1,130 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/31        Feng Yifei
+ */
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func request(t *testing.T, h http.Handler, method, path string, status int, v interface{}) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	if rec.Code != status {
+		t.Fatalf("%s %s = %d, want %d: %s", method, path, rec.Code, status, rec.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	m.Add(Job{Name: "report", Spec: "@hourly", Func: nop, Overlap: Queue})
+	m.Add(Job{Name: "daily backup", Spec: "@daily", Func: nop})
+	m.Start()
+
+	h := m.Handler()
+
+	var infos []Info
+	request(t, h, http.MethodGet, "/jobs", http.StatusOK, &infos)
+	if len(infos) != 2 || infos[0].Name != "daily backup" || infos[1].Overlap != "queue" {
+		t.Fatalf("infos = %+v", infos)
+	}
+	if infos[1].Next == nil || infos[1].Prev != nil || infos[1].Last != nil {
+		t.Fatalf("report = %+v", infos[1])
+	}
+
+	var info Info
+	request(t, h, http.MethodPost, "/jobs/report/pause", http.StatusOK, &info)
+	if !info.Paused {
+		t.Fatal("job not paused")
+	}
+
+	// 暂停的任务仍然可以手动执行
+	request(t, h, http.MethodPost, "/jobs/report/trigger", http.StatusAccepted, nil)
+	waitHistory(t, m, "report", 1)
+
+	request(t, h, http.MethodPost, "/jobs/report/resume", http.StatusOK, &info)
+	if info.Paused || info.Last == nil || info.Last.Status != StatusOK {
+		t.Fatalf("info = %+v", info)
+	}
+
+	var detail struct {
+		Name    string `json:"name"`
+		History []struct {
+			Status string `json:"status"`
+		} `json:"history"`
+	}
+	request(t, h, http.MethodGet, "/jobs/report", http.StatusOK, &detail)
+	if detail.Name != "report" || len(detail.History) != 1 || detail.History[0].Status != "ok" {
+		t.Fatalf("detail = %+v", detail)
+	}
+
+	request(t, h, http.MethodGet, "/jobs/daily%20backup", http.StatusOK, nil)
+	request(t, h, http.MethodGet, "/jobs/missing", http.StatusNotFound, nil)
+	request(t, h, http.MethodPost, "/jobs/missing/trigger", http.StatusNotFound, nil)
+	request(t, h, http.MethodGet, "/jobs/report/trigger", http.StatusMethodNotAllowed, nil)
+	request(t, h, http.MethodPost, "/jobs/report/explode", http.StatusNotFound, nil)
+	request(t, h, http.MethodDelete, "/jobs", http.StatusMethodNotAllowed, nil)
+}
+
+func TestAdminTriggerSkipped(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.Add(Job{Name: "report", Spec: "@hourly", Func: func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}})
+
+	h := m.Handler()
+	request(t, h, http.MethodPost, "/jobs/report/trigger", http.StatusAccepted, nil)
+	<-started
+
+	var info Info
+	request(t, h, http.MethodGet, "/jobs/report", http.StatusOK, &info)
+	if info.Running != 1 {
+		t.Fatalf("running = %d, want 1", info.Running)
+	}
+
+	request(t, h, http.MethodPost, "/jobs/report/trigger", http.StatusConflict, nil)
+	close(release)
+}
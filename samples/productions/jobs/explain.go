@@ -0,0 +1,469 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/01        Feng Yifei
+ */
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// Explanation cron 表达式的说明
+type Explanation struct {
+	Spec     string
+	English  string
+	Chinese  string
+	Warnings []Warning
+}
+
+// Warning 表达式中可能的错误
+type Warning struct {
+	English string
+	Chinese string
+	// 建议的表达式，没有建议时为空
+	Suggestion string
+}
+
+// starBit 与 cron 包中的定义相同，字段以 "*" 或 "?" 开头时被设置
+const starBit = 1 << 63
+
+// field 表达式中的一个字段
+type field struct {
+	en, ens string   // 英文单位的单数与复数
+	zh      string   // 中文单位
+	min     uint     // 取值范围
+	max     uint     //
+	names   []string // 英文名称，为 nil 时直接输出数值
+	zhNames []string // 中文名称，为 nil 时直接输出数值
+	bits    uint64
+}
+
+var (
+	weekdays   = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+	zhWeekdays = []string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}
+	months     = []string{"", "Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+)
+
+func newFields(s *cron.SpecSchedule) []*field {
+	return []*field{
+		{en: "second", ens: "seconds", zh: "秒", min: 0, max: 59, bits: s.Second},
+		{en: "minute", ens: "minutes", zh: "分钟", min: 0, max: 59, bits: s.Minute},
+		{en: "hour", ens: "hours", zh: "点", min: 0, max: 23, bits: s.Hour},
+		{en: "day", ens: "days", zh: "日", min: 1, max: 31, bits: s.Dom},
+		{en: "month", ens: "months", zh: "月", min: 1, max: 12, bits: s.Month, names: months},
+		{en: "weekday", ens: "weekdays", zh: "", min: 0, max: 6, bits: s.Dow, names: weekdays, zhNames: zhWeekdays},
+	}
+}
+
+func (f *field) values() []uint {
+	var values []uint
+	for v := f.min; v <= f.max; v++ {
+		if f.bits&(1<<v) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (f *field) all() bool {
+	return len(f.values()) == int(f.max-f.min+1)
+}
+
+func (f *field) star() bool {
+	return f.bits&starBit != 0
+}
+
+// step 判断取值是否为从 start 开始、间隔为 step、一直到上限的等差数列
+func (f *field) step() (start, step uint, ok bool) {
+	values := f.values()
+	if len(values) < 2 || f.all() {
+		return 0, 0, false
+	}
+
+	start, step = values[0], values[1]-values[0]
+	for i := 1; i < len(values); i++ {
+		if values[i]-values[i-1] != step {
+			return 0, 0, false
+		}
+	}
+	return start, step, step > 1 && values[len(values)-1]+step > f.max
+}
+
+// span 判断取值是否为连续的区间
+func (f *field) span() (from, to uint, ok bool) {
+	values := f.values()
+	if len(values) < 2 {
+		return 0, 0, false
+	}
+	from, to = values[0], values[len(values)-1]
+	return from, to, int(to-from+1) == len(values)
+}
+
+func (f *field) name(v uint) string {
+	if f.names != nil {
+		return f.names[v]
+	}
+	return fmt.Sprint(v)
+}
+
+func (f *field) zhName(v uint) string {
+	if f.zhNames != nil {
+		return f.zhNames[v]
+	}
+	return fmt.Sprint(v)
+}
+
+// list 列出所有取值，数量较多时省略中间的部分
+func (f *field) list(name func(uint) string, sep, last string) string {
+	values := f.values()
+	items := make([]string, 0, len(values))
+	for _, v := range values {
+		items = append(items, name(v))
+	}
+
+	if len(items) > 6 {
+		items = []string{items[0], items[1], items[2], "…", items[len(items)-1]}
+		return strings.Join(items, sep)
+	}
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], sep) + last + items[len(items)-1]
+}
+
+// enValues 以 "hours 8 through 16"、"minutes 0, 30 and 45" 的形式输出取值，有名称的字段不输出单位
+func (f *field) enValues() string {
+	var values string
+	if from, to, ok := f.span(); ok && to-from > 1 {
+		values = fmt.Sprintf("%s through %s", f.name(from), f.name(to))
+	} else {
+		values = f.list(f.name, ", ", " and ")
+	}
+
+	switch {
+	case f.names != nil:
+		return values
+	case len(f.values()) == 1:
+		return f.en + " " + values
+	}
+	return f.ens + " " + values
+}
+
+// zhValues 以 "8 到 16 点"、"第 0、30 分钟"、"周一到周五" 的形式输出取值
+func (f *field) zhValues() string {
+	if f.zhNames != nil {
+		if from, to, ok := f.span(); ok && to-from > 1 {
+			return f.zhName(from) + "到" + f.zhName(to)
+		}
+		return f.list(f.zhName, "、", "、")
+	}
+
+	var values string
+	if from, to, ok := f.span(); ok && to-from > 1 {
+		values = fmt.Sprintf("%d 到 %d", from, to)
+	} else {
+		values = f.list(f.zhName, "、", "、")
+	}
+
+	switch f.zh {
+	case "点", "日", "月":
+		return values + " " + f.zh
+	}
+	return "第 " + values + " " + f.zh
+}
+
+// enEvery 作为最小的非固定字段时的英文说明，例如 "every 5 minutes"
+func (f *field) enEvery() string {
+	if f.all() {
+		return "every " + f.en
+	}
+	if start, step, ok := f.step(); ok {
+		s := fmt.Sprintf("every %d %s", step, f.ens)
+		if start != f.min {
+			s += fmt.Sprintf(" starting at %s %d", f.en, start)
+		}
+		return s
+	}
+	if from, to, ok := f.span(); ok {
+		return fmt.Sprintf("every %s from %s %d through %d", f.en, f.en, from, to)
+	}
+	return "at " + f.enValues()
+}
+
+// zhEvery 作为最小的非固定字段时的中文说明，例如 "每 5 分钟"
+func (f *field) zhEvery() string {
+	unit := f.zh
+	if unit == "点" {
+		unit = "小时"
+	}
+
+	if f.all() {
+		return "每" + unit
+	}
+	if start, step, ok := f.step(); ok {
+		s := fmt.Sprintf("每 %d %s", step, unit)
+		if start != f.min {
+			s = fmt.Sprintf("从第 %d %s起%s", start, f.zh, s)
+		}
+		return s
+	}
+	if _, _, ok := f.span(); ok {
+		return f.zhValues() + "内每" + unit
+	}
+	return f.zhValues()
+}
+
+// enDuring 作为较大字段时的英文限定，例如 "during minutes 0, 5, 10, …, 55"，不限定时为空
+func (f *field) enDuring() string {
+	if f.all() {
+		return ""
+	}
+	return " during " + f.enValues()
+}
+
+// zhDuring 作为较大字段时的中文限定，例如 "第 0、5、10、…、55 分钟内"，不限定时为空
+func (f *field) zhDuring() string {
+	if f.all() {
+		return ""
+	}
+	return f.zhValues() + "内"
+}
+
+// Explain 使用 cron.Parse 解析 spec，返回英文与中文说明以及可能的错误
+func Explain(spec string) (Explanation, error) {
+	schedule, err := parseSpec(spec)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	ex := Explanation{Spec: spec}
+	switch s := schedule.(type) {
+	case cron.ConstantDelaySchedule:
+		ex.English = fmt.Sprintf("Every %v, counted from when the scheduler starts.", s.Delay)
+		ex.Chinese = fmt.Sprintf("从调度开始时算起，每 %v 执行一次。", s.Delay)
+		return ex, nil
+	case *cron.SpecSchedule:
+		fields := newFields(s)
+		ex.English, ex.Chinese = describe(fields)
+		ex.Warnings = check(spec, s, fields)
+		return ex, nil
+	}
+	return ex, fmt.Errorf("jobs: unsupported schedule %T", schedule)
+}
+
+// NextRuns 返回 from 之后的 n 个执行时间，时间使用 from 的时区；表达式永远不会触发时返回的数量少于 n
+func NextRuns(spec string, from time.Time, n int) ([]time.Time, error) {
+	schedule, err := parseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]time.Time, 0, n)
+	for t := from; len(runs) < n; {
+		t = schedule.Next(t)
+		if t.IsZero() {
+			break
+		}
+		runs = append(runs, t)
+	}
+	return runs, nil
+}
+
+func parseSpec(spec string) (cron.Schedule, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, fmt.Errorf("jobs: empty cron spec")
+	}
+	return cron.Parse(strings.TrimSpace(spec))
+}
+
+func describe(fields []*field) (en, zh string) {
+	sec, min, hour := fields[0], fields[1], fields[2]
+	single := func(f *field) bool { return len(f.values()) == 1 }
+
+	daily := false
+	switch {
+	case single(sec) && single(min) && single(hour):
+		en = fmt.Sprintf("at %02d:%02d:%02d", hour.values()[0], min.values()[0], sec.values()[0])
+		zh = en[3:] + " 执行"
+		daily = true
+	case single(sec) && single(min):
+		clock := fmt.Sprintf("%02d:%02d", min.values()[0], sec.values()[0])
+		hours := hour.enEvery()
+		if strings.HasPrefix(hours, "at ") {
+			hours = "during " + hours[3:]
+		}
+		en = fmt.Sprintf("at xx:%s %s", clock, hours)
+		zh = fmt.Sprintf("%s的第 %d 分 %d 秒执行", hour.zhEvery(), min.values()[0], sec.values()[0])
+	case single(sec):
+		en = fmt.Sprintf("%s, at second %d%s", min.enEvery(), sec.values()[0], hour.enDuring())
+		zh = fmt.Sprintf("%s%s的第 %d 秒执行", hour.zhDuring(), min.zhEvery(), sec.values()[0])
+	default:
+		en = sec.enEvery() + min.enDuring() + hour.enDuring()
+		zh = hour.zhDuring() + min.zhDuring() + sec.zhEvery() + "执行一次"
+	}
+
+	dayEn, dayZh := describeDays(fields, daily)
+	en = strings.ToUpper(en[:1]) + en[1:] + dayEn + "."
+	if dayZh != "" {
+		zh = dayZh + " " + zh
+	}
+	return en, zh + "。"
+}
+
+// describeDays 说明日期、月份与星期字段，daily 表示执行时间为每天固定的时刻
+func describeDays(fields []*field, daily bool) (en, zh string) {
+	dom, month, dow := fields[3], fields[4], fields[5]
+
+	// 日期与星期都被限定且都不以 "*" 开头时，两者满足其一即可
+	sep, zhSep := " and ", "且"
+	if !dom.star() && !dow.star() {
+		sep, zhSep = " or ", "或"
+	}
+
+	var ens, zhs []string
+	if !dom.all() {
+		ens = append(ens, "on "+dom.enValues()+" of the month")
+		if month.all() {
+			zhs = append(zhs, "每月 "+dom.zhValues())
+		} else {
+			zhs = append(zhs, dom.zhValues())
+		}
+	}
+	if !dow.all() {
+		ens = append(ens, "on "+dow.enValues())
+		zhs = append(zhs, dow.zhValues())
+	}
+	if len(ens) > 0 {
+		en = " " + strings.Join(ens, sep)
+	}
+	zh = strings.Join(zhs, zhSep)
+
+	if !month.all() {
+		en += " in " + month.enValues()
+		if zh == "" {
+			zh = "每年 " + month.zhValues() + "每天"
+		} else {
+			zh = "每年 " + month.zhValues() + " " + zh
+		}
+	} else if zh == "" && daily {
+		zh = "每天"
+	}
+	return en, zh
+}
+
+// check 检查常见的错误
+func check(spec string, s *cron.SpecSchedule, fields []*field) []Warning {
+	var warnings []Warning
+
+	parts := strings.Fields(spec)
+	if strings.HasPrefix(spec, "@") {
+		parts = nil
+	}
+
+	if len(parts) == 5 {
+		warnings = append(warnings, Warning{
+			English: "5 fields are read as \"second minute hour day-of-month month\", not as the standard crontab " +
+				"\"minute hour day-of-month month day-of-week\"; add a leading seconds field",
+			Chinese:    "5 个字段被解析为“秒 分 时 日 月”，而不是标准 crontab 的“分 时 日 月 星期”；请在前面加上秒字段",
+			Suggestion: "0 " + spec,
+		})
+		parts = append(parts, "*")
+	}
+
+	// 较大的字段被限定而较小的字段为 "*" 时，匹配期间每个较小的单位都会执行一次
+	top := -1
+	for i := 2; i >= 0; i-- {
+		if !fields[i].all() {
+			top = i
+			break
+		}
+	}
+	if !fields[3].all() || !fields[4].all() || !fields[5].all() {
+		top = 3
+	}
+	if top > 0 && fields[0].all() {
+		times := 1
+		suggestion := append([]string(nil), parts...)
+		for i := 0; i < top; i++ {
+			if fields[i].all() {
+				times *= int(fields[i].max - fields[i].min + 1)
+				if suggestion != nil {
+					suggestion[i] = "0"
+				}
+			}
+		}
+
+		units := []string{"second", "minute", "hour", "day"}
+		zhUnits := []string{"秒", "分钟", "小时", "天"}
+		w := Warning{
+			English: fmt.Sprintf("the second field is \"*\", so the job fires every second while the other fields match "+
+				"(%d times in each matching %s), not once; use \"0\" to fire once", times, units[top]),
+			Chinese: fmt.Sprintf("秒字段为 \"*\"，其他字段匹配期间每秒都会执行（每个匹配的%s内执行 %d 次），而不是只执行一次；"+
+				"只需执行一次时请改为 \"0\"", zhUnits[top], times),
+		}
+		if suggestion != nil {
+			w.Suggestion = strings.Join(suggestion, " ")
+		}
+		warnings = append(warnings, w)
+	}
+
+	if !fields[3].all() && !fields[5].all() && !fields[3].star() && !fields[5].star() {
+		warnings = append(warnings, Warning{
+			English: "day of month and day of week are both set, so the job fires when either of them matches, not only when both do",
+			Chinese: "同时指定了日期与星期，两者满足其一即会执行，而不是同时满足才执行",
+		})
+	}
+
+	for _, f := range fields[:3] {
+		start, step, ok := f.step()
+		if !ok {
+			continue
+		}
+		values := f.values()
+		last := values[len(values)-1]
+		if gap := f.max + 1 - last + start; gap != step {
+			warnings = append(warnings, Warning{
+				English: fmt.Sprintf("step %d does not divide the %s field evenly: %s %d is followed by %s %d only %d %s later",
+					step, f.en, f.en, last, f.en, start, gap, f.ens),
+				Chinese: fmt.Sprintf("间隔 %d 不能整除%s字段的范围：第 %d %s之后的下一次是第 %d %s，只相隔 %d %s",
+					step, f.zh, last, f.zh, start, f.zh, gap, f.zh),
+			})
+		}
+	}
+
+	if s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		warnings = append(warnings, Warning{
+			English: "the schedule never fires, for example because the day does not exist in the selected months",
+			Chinese: "该表达式永远不会触发，例如所选月份中不存在指定的日期",
+		})
+	}
+	return warnings
+}
@@ -0,0 +1,118 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/01        Feng Yifei
+ */
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	cases := []struct {
+		spec       string
+		english    string
+		chinese    string
+		warnings   int
+		suggestion string
+	}{
+		{"0 */5 * * * *", "Every 5 minutes, at second 0.", "每 5 分钟的第 0 秒执行。", 0, ""},
+		{"* */5 * * * *", "Every second during minutes 0, 5, 10, …, 55.", "第 0、5、10、…、55 分钟内每秒执行一次。", 1, "0 */5 * * * *"},
+		{"0 0 19 * * *", "At 19:00:00.", "每天 19:00:00 执行。", 0, ""},
+		{"* * 8-16 * * *", "Every second during hours 8 through 16.", "8 到 16 点内每秒执行一次。", 1, "0 0 8-16 * * *"},
+		{"0 0 9 * * Mon-Fri", "At 09:00:00 on Mon through Fri.", "周一到周五 09:00:00 执行。", 0, ""},
+		{"0 30 * * * *", "At xx:30:00 every hour.", "每小时的第 30 分 0 秒执行。", 0, ""},
+		{"0 0 12 * Jan-Mar *", "At 12:00:00 in Jan through Mar.", "每年 1 到 3 月每天 12:00:00 执行。", 0, ""},
+		{"*/5 * * * *", "Every 5 seconds.", "每 5 秒执行一次。", 1, "0 */5 * * * *"},
+		{"0 0 0 13 * Fri", "At 00:00:00 on day 13 of the month or on Fri.", "每月 13 日或周五 00:00:00 执行。", 1, ""},
+		{"0 */7 * * * *", "Every 7 minutes, at second 0.", "每 7 分钟的第 0 秒执行。", 1, ""},
+		{"0 0 0 30 Feb *", "At 00:00:00 on day 30 of the month in Feb.", "每年 2 月 30 日 00:00:00 执行。", 1, ""},
+		{"@hourly", "At xx:00:00 every hour.", "每小时的第 0 分 0 秒执行。", 0, ""},
+		{"@every 1h30m", "Every 1h30m0s, counted from when the scheduler starts.", "从调度开始时算起，每 1h30m0s 执行一次。", 0, ""},
+	}
+
+	for _, c := range cases {
+		ex, err := Explain(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if ex.English != c.english || ex.Chinese != c.chinese {
+			t.Errorf("%s:\n got %q %q\nwant %q %q", c.spec, ex.English, ex.Chinese, c.english, c.chinese)
+		}
+		if len(ex.Warnings) != c.warnings {
+			t.Errorf("%s: warnings = %+v, want %d", c.spec, ex.Warnings, c.warnings)
+			continue
+		}
+		if c.warnings > 0 && ex.Warnings[0].Suggestion != c.suggestion {
+			t.Errorf("%s: suggestion = %q, want %q", c.spec, ex.Warnings[0].Suggestion, c.suggestion)
+		}
+	}
+}
+
+func TestExplainWarningText(t *testing.T) {
+	ex, _ := Explain("* */5 * * * *")
+	w := ex.Warnings[0]
+	if !strings.Contains(w.English, "60 times in each matching minute") || !strings.Contains(w.Chinese, "执行 60 次") {
+		t.Fatalf("warning = %+v", w)
+	}
+}
+
+func TestExplainInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * *", "61 * * * * *", "@fortnightly"} {
+		if _, err := Explain(spec); err == nil {
+			t.Errorf("Explain(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	from := time.Date(2018, 8, 1, 18, 59, 0, 0, shanghai)
+
+	runs, err := NextRuns("0 0 19 * * *", from, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, run := range runs {
+		want := time.Date(2018, 8, 1+i, 19, 0, 0, 0, shanghai)
+		if !run.Equal(want) || run.Location() != shanghai {
+			t.Fatalf("runs[%d] = %v, want %v", i, run, want)
+		}
+	}
+
+	// 同一时刻在 UTC 中的下一次执行
+	runs, _ = NextRuns("0 0 19 * * *", from.UTC(), 1)
+	if want := time.Date(2018, 8, 1, 19, 0, 0, 0, time.UTC); !runs[0].Equal(want) {
+		t.Fatalf("utc run = %v, want %v", runs[0], want)
+	}
+
+	if runs, _ := NextRuns("0 0 0 30 Feb *", from, 3); len(runs) != 0 {
+		t.Fatalf("impossible schedule fired at %v", runs)
+	}
+}
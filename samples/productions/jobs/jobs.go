@@ -0,0 +1,745 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/30        Feng Yifei
+ */
+
+// Package jobs 在 cron.Cron 之上管理具名的定时任务：按名称添加、删除与替换任务，
+// 按任务设置上一次执行尚未结束时的处理策略与超时，并记录最近的执行历史。
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+var (
+	// ErrExists 添加的任务名称已经存在
+	ErrExists = errors.New("jobs: job already exists")
+	// ErrNotFound 任务不存在
+	ErrNotFound = errors.New("jobs: job not found")
+	// ErrSkipped 上一次执行尚未结束，按 Overlap 策略跳过了本次触发
+	ErrSkipped = errors.New("jobs: run skipped by overlap policy")
+	// ErrStopped 管理器已经停止
+	ErrStopped = errors.New("jobs: manager stopped")
+	// ErrAbandoned 取消之后正在进行的执行仍然没有在宽限时间内返回
+	ErrAbandoned = errors.New("jobs: runs not returned after cancel")
+)
+
+// Overlap 触发时上一次执行尚未结束的处理策略
+type Overlap int
+
+// 触发时上一次执行尚未结束的处理策略
+const (
+	// Skip 跳过本次触发
+	Skip Overlap = iota
+	// Queue 排队，上一次结束后立即执行，排队数超过 MaxQueued 时跳过
+	Queue
+	// Concurrent 同时执行
+	Concurrent
+)
+
+func (o Overlap) String() string {
+	switch o {
+	case Skip:
+		return "skip"
+	case Queue:
+		return "queue"
+	case Concurrent:
+		return "concurrent"
+	}
+	return fmt.Sprintf("Overlap(%d)", int(o))
+}
+
+// Func 任务函数，ctx 在超时或管理器停止时结束
+type Func func(ctx context.Context) error
+
+// Job 具名的定时任务
+type Job struct {
+	// 任务名称，在管理器中唯一
+	Name string
+	// cron 表达式，格式与 cron.Parse 相同
+	Spec string
+	// 任务函数
+	Func Func
+	// 上一次执行尚未结束时的处理策略
+	Overlap Overlap
+	// 单次执行的超时时间，为 0 时不限制
+	Timeout time.Duration
+	// Queue 策略下最多排队的次数，小于 1 时按 1 处理
+	MaxQueued int
+	// 计算执行时间使用的时区，为 nil 时使用 time.Local
+	Location *time.Location
+	// 启动时发现进程停止期间错过了触发的处理策略，需要配合 LoadState 使用
+	Missed Missed
+	// 锁文件路径，不为空时每次执行前先通过 TryLock 加锁，
+	// 锁被同一台机器上的其它实例持有时跳过本次执行并记录日志
+	Lock string
+}
+
+// Status 单次执行的结果
+type Status int
+
+// 单次执行的结果
+const (
+	StatusOK Status = iota
+	StatusFailed
+	StatusTimeout
+	StatusSkipped
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusFailed:
+		return "failed"
+	case StatusTimeout:
+		return "timeout"
+	case StatusSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// MarshalText 以名称的形式输出结果
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText 解析 MarshalText 输出的名称
+func (s *Status) UnmarshalText(text []byte) error {
+	for _, status := range []Status{StatusOK, StatusFailed, StatusTimeout, StatusSkipped} {
+		if string(text) == status.String() {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("jobs: unknown status %q", text)
+}
+
+// Record 单次执行的记录
+type Record struct {
+	Job      string        `json:"job"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Status   Status        `json:"status"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (r Record) String() string {
+	s := fmt.Sprintf("%s %s %s %v", r.Job, r.Start.Format(time.RFC3339), r.Status, r.Duration)
+	if r.Err != nil {
+		s += ": " + r.Err.Error()
+	}
+	return s
+}
+
+// DefaultHistory 每个任务默认保留的执行记录数量
+const DefaultHistory = 20
+
+// DefaultGrace Shutdown 取消正在进行的执行后默认等待它们返回的时间
+const DefaultGrace = 5 * time.Second
+
+// Manager 定时任务管理器。
+// 每个任务以 *entry 的形式注册到 cron.Cron；cron.Cron 不支持删除任务，
+// 删除或替换任务时用剩余的任务重建 cron.Cron，正在执行的任务不受影响。
+type Manager struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	running bool
+	jobs    map[string]*entry
+	history int
+
+	ctx     context.Context // 所有执行的父 context，Stop 时取消
+	cancel  context.CancelFunc
+	runMu   sync.Mutex
+	stopped bool           // Stop 之后不再开始新的执行
+	wg      sync.WaitGroup // 正在执行的任务
+	grace   time.Duration  // Shutdown 取消执行后等待它们返回的时间
+
+	fired *fired // 每个任务最后一次成功完成的触发时间
+	// 计算触发与补执行时间使用的时钟，测试中可以替换
+	now func() time.Time
+}
+
+// New 创建管理器，history 为每个任务保留的执行记录数量，小于 1 时使用 DefaultHistory
+func New(history int) *Manager {
+	if history < 1 {
+		history = DefaultHistory
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cron:    cron.New(),
+		jobs:    make(map[string]*entry),
+		history: history,
+		ctx:     ctx,
+		cancel:  cancel,
+		grace:   DefaultGrace,
+		fired:   &fired{last: make(map[string]time.Time)},
+		now:     time.Now,
+	}
+}
+
+// Add 添加任务，名称已经存在时返回 ErrExists
+func (m *Manager) Add(job Job) error {
+	e, err := m.newEntry(job)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[job.Name]; ok {
+		return ErrExists
+	}
+	m.jobs[job.Name] = e
+	m.cron.Schedule(e.schedule, e)
+	if m.running {
+		m.catchUp([]*entry{e}, m.now())
+	}
+	return nil
+}
+
+// Replace 替换同名任务，任务不存在时添加。
+// 旧任务正在进行的执行不受影响，新任务沿用旧任务的执行状态与历史，Overlap 策略对两者同时生效。
+func (m *Manager) Replace(job Job) error {
+	e, err := m.newEntry(job)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.jobs[job.Name]
+	m.jobs[job.Name] = e
+	if !ok {
+		m.cron.Schedule(e.schedule, e)
+		if m.running {
+			m.catchUp([]*entry{e}, m.now())
+		}
+		return nil
+	}
+
+	e.state = old.state
+	m.rebuild()
+	return nil
+}
+
+// Remove 删除任务，正在进行的执行不受影响，状态文件中该任务的触发时间同时删除
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.jobs, name)
+	m.rebuild()
+	m.fired.remove(name)
+	return nil
+}
+
+// Names 返回所有任务的名称，按名称排序
+func (m *Manager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// History 返回任务最近的执行记录，按开始时间从早到晚排列
+func (m *Manager) History(name string) ([]Record, error) {
+	e, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+
+	records := make([]Record, len(e.state.records))
+	copy(records, e.state.records)
+	return records, nil
+}
+
+// Pause 暂停任务，暂停期间按计划的触发被忽略，正在进行的执行不受影响
+func (m *Manager) Pause(name string) error {
+	return m.setPaused(name, true)
+}
+
+// Resume 恢复暂停的任务
+func (m *Manager) Resume(name string) error {
+	return m.setPaused(name, false)
+}
+
+func (m *Manager) setPaused(name string, paused bool) error {
+	e, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	e.state.mu.Lock()
+	e.state.paused = paused
+	e.state.mu.Unlock()
+	return nil
+}
+
+// Trigger 立即执行一次任务，暂停的任务同样执行。
+// 执行仍然遵循 Overlap 策略：被跳过时返回 ErrSkipped，管理器已经停止时返回 ErrStopped
+func (m *Manager) Trigger(name string) error {
+	e, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	return e.dispatch(time.Time{})
+}
+
+// Info 任务的当前状态
+type Info struct {
+	Name    string        `json:"name"`
+	Spec    string        `json:"spec"`
+	Overlap string        `json:"overlap"`
+	Missed  string        `json:"missed"`
+	Timeout time.Duration `json:"timeout"`
+	// 计算执行时间使用的时区
+	Location string `json:"location"`
+	// 锁文件路径，没有设置时为空
+	Lock   string `json:"lock,omitempty"`
+	Paused bool   `json:"paused"`
+	// 正在进行与排队的执行数量
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+	// 上一次与下一次按计划触发的时间，没有时为 nil
+	Prev *time.Time `json:"prev,omitempty"`
+	Next *time.Time `json:"next,omitempty"`
+	// 最近一次执行的记录
+	Last *Record `json:"last,omitempty"`
+}
+
+// Jobs 返回所有任务的当前状态，按名称排序
+func (m *Manager) Jobs() []Info {
+	m.mu.Lock()
+	entries := m.cron.Entries()
+	jobs := make(map[string]*entry, len(m.jobs))
+	for name, e := range m.jobs {
+		jobs[name] = e
+	}
+	m.mu.Unlock()
+
+	infos := make([]Info, 0, len(jobs))
+	for _, e := range jobs {
+		infos = append(infos, e.info(entries))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Job 返回任务的当前状态
+func (m *Manager) Job(name string) (Info, error) {
+	m.mu.Lock()
+	entries := m.cron.Entries()
+	e, ok := m.jobs[name]
+	m.mu.Unlock()
+
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return e.info(entries), nil
+}
+
+// Start 开始调度，开始之前按各任务的 Missed 策略处理停止期间错过的触发
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		entries := make([]*entry, 0, len(m.jobs))
+		for _, e := range m.jobs {
+			entries = append(entries, e)
+		}
+		m.catchUp(entries, m.now())
+
+		m.running = true
+		m.cron.Start()
+	}
+}
+
+// Stop 停止调度并取消正在进行的执行，等待它们返回。Stop 之后管理器不能再次启动
+func (m *Manager) Stop() {
+	m.halt()
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Shutdown 停止调度，丢弃排队中的执行，等待正在进行的执行结束。
+// ctx 结束时取消正在进行的执行，再最多等待宽限时间让它们返回并返回 ctx.Err()；
+// 仍然没有返回时返回 ErrAbandoned，不再等待。Shutdown 之后管理器不能再次启动
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.halt()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	defer m.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.cancel()
+	m.runMu.Lock()
+	grace := m.grace
+	m.runMu.Unlock()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return ctx.Err()
+	case <-timer.C:
+		return ErrAbandoned
+	}
+}
+
+// SetGrace 设置 Shutdown 取消执行后等待它们返回的时间，默认为 DefaultGrace
+func (m *Manager) SetGrace(grace time.Duration) {
+	m.runMu.Lock()
+	m.grace = grace
+	m.runMu.Unlock()
+}
+
+// halt 停止调度，之后不再开始新的执行
+func (m *Manager) halt() {
+	m.mu.Lock()
+	if m.running {
+		m.running = false
+		m.cron.Stop()
+	}
+	m.mu.Unlock()
+
+	m.runMu.Lock()
+	m.stopped = true
+	m.runMu.Unlock()
+}
+
+// begin 登记一次新的执行，管理器已经停止时返回 false
+func (m *Manager) begin() bool {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	if m.stopped {
+		return false
+	}
+	m.wg.Add(1)
+	return true
+}
+
+// halted 返回管理器是否已经停止
+func (m *Manager) halted() bool {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	return m.stopped
+}
+
+// rebuild 用当前的任务重建 cron.Cron，调用方需持有 m.mu
+func (m *Manager) rebuild() {
+	if m.running {
+		m.cron.Stop()
+	}
+
+	m.cron = cron.New()
+	for _, e := range m.jobs {
+		m.cron.Schedule(e.schedule, e)
+	}
+
+	if m.running {
+		m.cron.Start()
+	}
+}
+
+func (m *Manager) lookup(name string) (*entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+func (m *Manager) newEntry(job Job) (*entry, error) {
+	if job.Name == "" {
+		return nil, errors.New("jobs: empty job name")
+	}
+	if job.Func == nil {
+		return nil, fmt.Errorf("jobs: job %s has no func", job.Name)
+	}
+
+	schedule, err := cron.Parse(job.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: job %s: %v", job.Name, err)
+	}
+
+	if job.MaxQueued < 1 {
+		job.MaxQueued = 1
+	}
+	if job.Location == nil {
+		job.Location = time.Local
+	}
+	schedule = zoned{Schedule: schedule, loc: job.Location}
+	return &entry{manager: m, job: job, schedule: schedule, state: &state{}}, nil
+}
+
+// entry 注册到 cron.Cron 的任务
+type entry struct {
+	manager  *Manager
+	job      Job
+	schedule cron.Schedule
+	state    *state
+}
+
+// state 任务的执行状态与历史，替换任务时由新旧任务共享
+type state struct {
+	mu      sync.Mutex
+	active  int         // 正在进行的执行数量
+	pending []time.Time // 排队的执行对应的触发时间，手动触发为零值
+	paused  bool        // 暂停时 cron.Cron 的触发被忽略，Trigger 仍然可以执行
+	prev    time.Time   // 上一次按计划触发的时间，重建 cron.Cron 后仍然保留
+	records []Record
+}
+
+// Run 由 cron.Cron 在独立的 goroutine 中调用，任务暂停时不执行。
+// 触发时间在执行成功后才写入状态文件，执行失败或执行过程中进程退出时重启后按 Missed 策略补执行；
+// 暂停是有意跳过，暂停期间的触发直接记录，重启后不会补执行
+func (e *entry) Run() {
+	now := e.manager.now()
+	at := now.Truncate(time.Second)
+
+	e.state.mu.Lock()
+	paused := e.state.paused
+	if !paused {
+		e.state.prev = now
+	}
+	e.state.mu.Unlock()
+
+	if paused {
+		e.manager.fired.mark(e.job.Name, at)
+		return
+	}
+	e.dispatch(at)
+}
+
+// dispatch 按 Overlap 策略开始一次执行或排队，跳过时返回 ErrSkipped。
+// at 为本次执行对应的触发时间，为零值时执行结果不写入状态文件
+func (e *entry) dispatch(at time.Time) error {
+	st := e.state
+
+	st.mu.Lock()
+	if st.active > 0 {
+		switch e.job.Overlap {
+		case Skip:
+			st.mu.Unlock()
+			e.record(Record{Job: e.job.Name, Start: time.Now(), Status: StatusSkipped})
+			return ErrSkipped
+		case Queue:
+			if len(st.pending) >= e.job.MaxQueued {
+				st.mu.Unlock()
+				e.record(Record{Job: e.job.Name, Start: time.Now(), Status: StatusSkipped})
+				return ErrSkipped
+			}
+			st.pending = append(st.pending, at)
+			st.mu.Unlock()
+			return nil
+		}
+	}
+	defer st.mu.Unlock()
+	return e.launch(at, nil)
+}
+
+// enqueue 依次执行 missed 中的每次触发，不受 Overlap 与 MaxQueued 的限制，用于补执行错过的触发
+func (e *entry) enqueue(missed []time.Time) error {
+	st := e.state
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.active > 0 {
+		st.pending = append(st.pending, missed...)
+		return nil
+	}
+	return e.launch(missed[0], missed[1:])
+}
+
+// launch 启动执行 goroutine，依次完成触发时间为 at 的本次执行与 pending 中排队的执行，
+// 成功后将触发时间写入状态文件。调用方需持有 e.state.mu
+func (e *entry) launch(at time.Time, pending []time.Time) error {
+	st := e.state
+
+	if !e.manager.begin() {
+		return ErrStopped
+	}
+	st.active++
+	st.pending = append(st.pending, pending...)
+
+	go func() {
+		defer e.manager.wg.Done()
+
+		for {
+			r := e.execute()
+			e.record(r)
+			if r.Status == StatusOK && !at.IsZero() {
+				e.manager.fired.mark(e.job.Name, at)
+			}
+
+			// 管理器停止后丢弃排队的执行
+			stopped := e.manager.halted()
+			st.mu.Lock()
+			if len(st.pending) == 0 || stopped {
+				st.active--
+				st.pending = nil
+				st.mu.Unlock()
+				return
+			}
+			at = st.pending[0]
+			st.pending = st.pending[1:]
+			st.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// info 根据 cron.Cron 的快照 entries 生成任务状态
+func (e *entry) info(entries []*cron.Entry) Info {
+	info := Info{
+		Name:     e.job.Name,
+		Spec:     e.job.Spec,
+		Overlap:  e.job.Overlap.String(),
+		Missed:   e.job.Missed.String(),
+		Timeout:  e.job.Timeout,
+		Location: e.job.Location.String(),
+		Lock:     e.job.Lock,
+	}
+
+	for _, ce := range entries {
+		if ce.Job == e && !ce.Next.IsZero() {
+			next := ce.Next
+			info.Next = &next
+		}
+	}
+
+	st := e.state
+	st.mu.Lock()
+	if !st.prev.IsZero() {
+		prev := st.prev
+		info.Prev = &prev
+	}
+	info.Paused = st.paused
+	info.Running = st.active
+	info.Queued = len(st.pending)
+	if n := len(st.records); n > 0 {
+		last := st.records[n-1]
+		info.Last = &last
+	}
+	st.mu.Unlock()
+	return info
+}
+
+// execute 执行一次任务，任务函数 panic 时记为失败，无法获得锁时记为跳过
+func (e *entry) execute() (r Record) {
+	if e.job.Lock != "" {
+		lock, err := TryLock(e.job.Lock)
+		if err != nil {
+			log.Printf("jobs: %s skipped: %v", e.job.Name, err)
+			return Record{Job: e.job.Name, Start: time.Now(), Status: StatusSkipped, Err: err, Error: err.Error()}
+		}
+		defer lock.Unlock()
+	}
+
+	ctx, cancel := e.manager.ctx, context.CancelFunc(func() {})
+	if e.job.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, e.job.Timeout)
+	}
+	defer cancel()
+
+	r = Record{Job: e.job.Name, Start: time.Now()}
+	defer func() {
+		if v := recover(); v != nil {
+			r.Err = fmt.Errorf("panic: %v", v)
+		}
+
+		r.Duration = time.Since(r.Start)
+		switch {
+		case r.Err == nil:
+			r.Status = StatusOK
+		case ctx.Err() == context.DeadlineExceeded:
+			r.Status = StatusTimeout
+		default:
+			r.Status = StatusFailed
+		}
+		if r.Err != nil {
+			r.Error = r.Err.Error()
+		}
+	}()
+
+	r.Err = e.job.Func(ctx)
+	return r
+}
+
+func (e *entry) record(r Record) {
+	st := e.state
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.records = append(st.records, r)
+	if n := len(st.records) - e.manager.history; n > 0 {
+		st.records = append(st.records[:0:0], st.records[n:]...)
+	}
+}
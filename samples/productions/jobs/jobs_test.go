@@ -0,0 +1,388 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/30        Feng Yifei
+ */
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fire 像 cron.Cron 一样触发一次任务
+func fire(t *testing.T, m *Manager, name string) {
+	e, err := m.lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Run()
+}
+
+// waitHistory 等待任务至少有 n 条执行记录
+func waitHistory(t *testing.T, m *Manager, name string, n int) []Record {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records, err := m.History(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) >= n {
+			return records
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d records, want %d", len(records), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitIdle 等待任务没有正在进行的执行
+func waitIdle(t *testing.T, m *Manager, name string) {
+	e, err := m.lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		e.state.mu.Lock()
+		active := e.state.active
+		e.state.mu.Unlock()
+
+		if active == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func count(records []Record, status Status) int {
+	n := 0
+	for _, r := range records {
+		if r.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func nop(context.Context) error { return nil }
+
+func TestAddRemoveReplace(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	if err := m.Add(Job{Name: "report", Spec: "0 0 * * * *", Func: nop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(Job{Name: "report", Spec: "0 0 * * * *", Func: nop}); err != ErrExists {
+		t.Fatalf("duplicate Add = %v, want ErrExists", err)
+	}
+	if err := m.Add(Job{Name: "bad", Spec: "* * *", Func: nop}); err == nil {
+		t.Fatal("Add accepted an invalid spec")
+	}
+	if err := m.Replace(Job{Name: "cleanup", Spec: "@daily", Func: nop}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Start()
+	if err := m.Replace(Job{Name: "report", Spec: "@hourly", Func: nop}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.cron.Entries()); n != 2 {
+		t.Fatalf("%d cron entries, want 2", n)
+	}
+
+	if err := m.Remove("cleanup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("cleanup"); err != ErrNotFound {
+		t.Fatalf("second Remove = %v, want ErrNotFound", err)
+	}
+
+	if names := m.Names(); len(names) != 1 || names[0] != "report" {
+		t.Fatalf("names = %v", names)
+	}
+	if n := len(m.cron.Entries()); n != 1 {
+		t.Fatalf("%d cron entries, want 1", n)
+	}
+}
+
+// blocking 返回在 release 关闭前一直阻塞的任务函数，running 记录同时执行的数量
+func blocking(release <-chan struct{}, running, peak *int32) Func {
+	return func(context.Context) error {
+		n := atomic.AddInt32(running, 1)
+		for {
+			p := atomic.LoadInt32(peak)
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(running, -1)
+		return nil
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	cases := []struct {
+		overlap     Overlap
+		ok, skipped int
+		peak        int32
+		running     int32 // 释放前需要等到的同时执行数量
+	}{
+		{Skip, 1, 2, 1, 1},
+		{Queue, 2, 1, 1, 1},
+		{Concurrent, 3, 0, 3, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.overlap.String(), func(t *testing.T) {
+			m := New(0)
+			defer m.Stop()
+
+			var running, peak int32
+			release := make(chan struct{})
+			m.Add(Job{Name: "report", Spec: "@hourly", Func: blocking(release, &running, &peak), Overlap: c.overlap})
+
+			fire(t, m, "report")
+			for atomic.LoadInt32(&running) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			fire(t, m, "report")
+			fire(t, m, "report")
+			for atomic.LoadInt32(&running) < c.running {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+
+			records := waitHistory(t, m, "report", 3)
+			if count(records, StatusOK) != c.ok || count(records, StatusSkipped) != c.skipped {
+				t.Fatalf("records = %v", records)
+			}
+			if peak != c.peak {
+				t.Fatalf("peak concurrency = %d, want %d", peak, c.peak)
+			}
+		})
+	}
+}
+
+func TestReplaceKeepsOverlap(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	var running, peak int32
+	release := make(chan struct{})
+	m.Add(Job{Name: "report", Spec: "@hourly", Func: blocking(release, &running, &peak)})
+
+	fire(t, m, "report")
+	for atomic.LoadInt32(&running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 替换后的任务仍然能看到旧任务正在执行
+	m.Replace(Job{Name: "report", Spec: "@daily", Func: nop})
+	fire(t, m, "report")
+	close(release)
+
+	records := waitHistory(t, m, "report", 2)
+	if records[0].Status != StatusSkipped || records[1].Status != StatusOK {
+		t.Fatalf("records = %v", records)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	m.Add(Job{Name: "slow", Spec: "@hourly", Timeout: 10 * time.Millisecond, Func: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	fire(t, m, "slow")
+
+	r := waitHistory(t, m, "slow", 1)[0]
+	if r.Status != StatusTimeout || r.Duration < 10*time.Millisecond || r.Error == "" {
+		t.Fatalf("record = %v", r)
+	}
+}
+
+func TestFailureAndPanic(t *testing.T) {
+	m := New(0)
+	defer m.Stop()
+
+	m.Add(Job{Name: "fail", Spec: "@hourly", Func: func(context.Context) error { return errors.New("disk full") }})
+	m.Add(Job{Name: "panic", Spec: "@hourly", Func: func(context.Context) error { panic("boom") }})
+	fire(t, m, "fail")
+	fire(t, m, "panic")
+
+	if r := waitHistory(t, m, "fail", 1)[0]; r.Status != StatusFailed || r.Error != "disk full" {
+		t.Fatalf("record = %v", r)
+	}
+	if r := waitHistory(t, m, "panic", 1)[0]; r.Status != StatusFailed || r.Error != "panic: boom" {
+		t.Fatalf("record = %v", r)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	m := New(3)
+	defer m.Stop()
+
+	m.Add(Job{Name: "count", Spec: "@hourly", Func: nop})
+
+	for i := 0; i < 5; i++ {
+		fire(t, m, "count")
+		waitIdle(t, m, "count")
+	}
+
+	if records, _ := m.History("count"); len(records) != 3 {
+		t.Fatalf("%d records, want 3", len(records))
+	}
+}
+
+func TestStopCancels(t *testing.T) {
+	m := New(0)
+
+	started := make(chan struct{})
+	m.Add(Job{Name: "wait", Spec: "@hourly", Func: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	m.Start()
+	fire(t, m, "wait")
+	<-started
+
+	m.Stop()
+	records, _ := m.History("wait")
+	if len(records) != 1 || records[0].Status != StatusFailed {
+		t.Fatalf("records = %v", records)
+	}
+
+	// 停止后不再开始新的执行
+	fire(t, m, "wait")
+	if records, _ := m.History("wait"); len(records) != 1 {
+		t.Fatalf("records = %v", records)
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	m := New(0)
+
+	var running, peak int32
+	release := make(chan struct{})
+	m.Add(Job{Name: "export", Spec: "@hourly", Func: blocking(release, &running, &peak), Overlap: Queue})
+	m.Start()
+
+	fire(t, m, "export")
+	for atomic.LoadInt32(&running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fire(t, m, "export")
+
+	done := make(chan error)
+	go func() {
+		done <- m.Shutdown(context.Background())
+	}()
+
+	// 等待正在进行的执行结束
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the run finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 排队中的执行被丢弃
+	records, _ := m.History("export")
+	if len(records) != 1 || records[0].Status != StatusOK {
+		t.Fatalf("records = %v", records)
+	}
+	if err := m.Trigger("export"); err != ErrStopped {
+		t.Fatalf("Trigger after Shutdown = %v, want ErrStopped", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	m := New(0)
+
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	m.Add(Job{Name: "wait", Spec: "@hourly", Func: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}})
+	fire(t, m, "wait")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := m.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+
+	// 取消后等待执行返回，Shutdown 返回时执行已经结束
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("Shutdown returned before the canceled run")
+	}
+}
+
+func TestShutdownGrace(t *testing.T) {
+	m := New(0)
+	m.SetGrace(10 * time.Millisecond)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.Add(Job{Name: "stuck", Spec: "@hourly", Func: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}})
+	fire(t, m, "stuck")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// 执行忽略取消时只等待宽限时间
+	if err := m.Shutdown(ctx); err != ErrAbandoned {
+		t.Fatalf("Shutdown = %v, want ErrAbandoned", err)
+	}
+	close(release)
+	m.Stop()
+}
@@ -0,0 +1,149 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/03        Feng Yifei
+ */
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestTryLock(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "job.lock")
+
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock 按打开的文件区分持有者，同一进程再次打开同样会冲突
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second TryLock = %v, want ErrLocked", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock after Unlock = %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestTryLockStale(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "job.lock")
+
+	// 已经退出的进程的 PID
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	dead := cmd.Process.Pid
+
+	// 模拟继承了文件描述符的进程仍然持有锁，而记录的持有者已经退出
+	held, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Unlock()
+	if err := held.file.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := held.file.WriteAt([]byte(strconv.Itoa(dead)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock over stale lock = %v", err)
+	}
+	defer lock.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; string(data) != want {
+		t.Fatalf("lock file = %q, want %q", data, want)
+	}
+}
+
+func TestJobLock(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "export.lock")
+
+	m := New(0)
+	defer m.Stop()
+
+	ran := make(chan struct{}, 2)
+	m.Add(Job{Name: "export", Spec: "@hourly", Lock: path, Func: func(context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}})
+
+	// 其它实例持有锁时跳过
+	other, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fire(t, m, "export")
+	records := waitHistory(t, m, "export", 1)
+	if records[0].Status != StatusSkipped || len(ran) != 0 {
+		t.Fatalf("records = %v", records)
+	}
+
+	other.Unlock()
+	fire(t, m, "export")
+	records = waitHistory(t, m, "export", 2)
+	if records[1].Status != StatusOK || len(ran) != 1 {
+		t.Fatalf("records = %v", records)
+	}
+}
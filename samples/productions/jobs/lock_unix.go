@@ -0,0 +1,123 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/03        Feng Yifei
+ */
+
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// TryLock 以不阻塞的方式对 path 加 flock 排它锁，成功后把当前进程的 PID 写入锁文件。
+// 锁已被持有时返回包装了 ErrLocked 的错误；如果锁文件中记录的进程已经不存在，
+// 说明锁由继承了文件描述符的其它进程持有，此时删除锁文件并重新创建后再次尝试
+func TryLock(path string) (*FileLock, error) {
+	pid := 0
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			// 打开之后锁文件被其它实例当作过期锁删除，需要重新打开
+			if !samePath(file, path) {
+				file.Close()
+				continue
+			}
+			if err = writePID(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+			return &FileLock{file: file}, nil
+		}
+		if err != unix.EWOULDBLOCK {
+			file.Close()
+			return nil, err
+		}
+
+		// PID 为 0 时持有者刚刚加锁、尚未写入 PID，不能视为过期
+		pid = readPID(file)
+		if pid == 0 || alive(pid) {
+			file.Close()
+			return nil, fmt.Errorf("%w: %s held by pid %d", ErrLocked, path, pid)
+		}
+		if samePath(file, path) {
+			os.Remove(path)
+		}
+		file.Close()
+	}
+	return nil, fmt.Errorf("%w: %s held by pid %d", ErrLocked, path, pid)
+}
+
+// samePath 判断 path 当前是否仍然指向 file
+func samePath(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+func writePID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// readPID 读取锁文件中记录的 PID，无法识别时返回 0
+func readPID(file *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(buf[:n])))
+	if err != nil || pid < 0 {
+		return 0
+	}
+	return pid
+}
+
+// alive 判断进程是否存在，没有权限发送信号的进程同样存在
+func alive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
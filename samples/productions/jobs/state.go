@@ -0,0 +1,205 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/02        Feng Yifei
+ */
+
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+
+	"github.com/TechCatsLab/gosnippet/samples/productions/internal/atomicfile"
+)
+
+// Missed 进程停止期间错过了触发时的处理策略
+type Missed int
+
+// 错过触发时的处理策略
+const (
+	// MissedSkip 忽略错过的触发，等待下一次
+	MissedSkip Missed = iota
+	// MissedOnce 启动后补执行一次，适合每天只需要执行一次的任务
+	MissedOnce
+	// MissedAll 启动后按错过的次数依次补执行
+	MissedAll
+)
+
+func (m Missed) String() string {
+	switch m {
+	case MissedSkip:
+		return "skip"
+	case MissedOnce:
+		return "once"
+	case MissedAll:
+		return "all"
+	}
+	return fmt.Sprintf("Missed(%d)", int(m))
+}
+
+// zoned 在指定时区中计算下一次执行时间，cron.Cron 传入的时间总是 time.Local
+type zoned struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+func (z zoned) Next(t time.Time) time.Time {
+	return z.Schedule.Next(t.In(z.loc))
+}
+
+// stateFile 状态文件的格式
+type stateFile struct {
+	Jobs map[string]time.Time `json:"jobs"`
+}
+
+// fired 记录每个任务最后一次成功完成的触发时间，设置了 path 时每次变化都写入状态文件
+type fired struct {
+	mu   sync.Mutex
+	path string
+	last map[string]time.Time
+	err  error // 最近一次写入状态文件的错误
+}
+
+// LoadState 从 path 读取各任务最后一次成功完成的触发时间，并在之后每次执行成功时写回该文件，
+// 文件不存在时从空状态开始。需要在 Start 之前调用。
+//
+// 触发时间在执行成功之后写入，执行失败、因锁被跳过或执行过程中进程退出的触发在重启后按 Missed 策略补执行，
+// 即每次触发至少执行一次，任务函数需要能够容忍重复执行。
+func (m *Manager) LoadState(path string) error {
+	file := stateFile{Jobs: make(map[string]time.Time)}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("jobs: invalid state file %s: %v", path, err)
+		}
+		if file.Jobs == nil {
+			file.Jobs = make(map[string]time.Time)
+		}
+	}
+
+	m.fired.mu.Lock()
+	defer m.fired.mu.Unlock()
+
+	m.fired.path = path
+	m.fired.last = file.Jobs
+	m.fired.err = nil
+	return nil
+}
+
+// StateErr 返回最近一次写入状态文件的错误，写入成功后清除
+func (m *Manager) StateErr() error {
+	m.fired.mu.Lock()
+	defer m.fired.mu.Unlock()
+
+	return m.fired.err
+}
+
+// catchUp 按各任务的 Missed 策略补执行 now 之前错过的触发，调用方需持有 m.mu。
+// 状态中没有记录的任务以 now 作为起点，不补执行
+func (m *Manager) catchUp(entries []*entry, now time.Time) {
+	for _, e := range entries {
+		last, ok := m.fired.get(e.job.Name)
+		if !ok {
+			m.fired.mark(e.job.Name, now.Truncate(time.Second))
+			continue
+		}
+
+		var missed []time.Time
+		for t := e.schedule.Next(last); !t.IsZero() && !t.After(now); t = e.schedule.Next(t) {
+			missed = append(missed, t)
+		}
+		if len(missed) == 0 {
+			continue
+		}
+
+		// 补执行成功后才推进状态，MissedSkip 有意忽略错过的触发，直接推进
+		switch e.job.Missed {
+		case MissedSkip:
+			m.fired.mark(e.job.Name, missed[len(missed)-1])
+		case MissedOnce:
+			e.enqueue(missed[len(missed)-1:])
+		case MissedAll:
+			e.enqueue(missed)
+		}
+	}
+}
+
+// get 返回任务最后一次成功完成的触发时间
+func (f *fired) get(name string) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.last[name]
+	return t, ok
+}
+
+// mark 记录任务成功完成的触发时间，只向后推进，并发执行先后完成时不会回退
+func (f *fired) mark(name string, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if last, ok := f.last[name]; ok && !t.After(last) {
+		return
+	}
+	f.last[name] = t
+	f.save()
+}
+
+// remove 删除任务的触发时间
+func (f *fired) remove(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.last[name]; ok {
+		delete(f.last, name)
+		f.save()
+	}
+}
+
+// save 写入状态文件，调用方需持有 f.mu
+func (f *fired) save() {
+	if f.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(stateFile{Jobs: f.last}, "", "  ")
+	if err == nil {
+		err = atomicfile.WriteFile(f.path, data)
+	}
+	f.err = err
+}
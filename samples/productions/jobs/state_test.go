@@ -0,0 +1,259 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/02        Feng Yifei
+ */
+
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestZonedNext(t *testing.T) {
+	m := New(0)
+	shanghai := time.FixedZone("CST", 8*3600)
+
+	e, err := m.newEntry(Job{Name: "bill", Spec: "0 0 19 * * *", Func: nop, Location: shanghai})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// UTC 10:00 即北京时间 18:00，下一次执行为北京时间当天 19:00
+	from := time.Date(2018, 8, 2, 10, 0, 0, 0, time.UTC)
+	want := time.Date(2018, 8, 2, 11, 0, 0, 0, time.UTC)
+	if next := e.schedule.Next(from); !next.Equal(want) {
+		t.Fatalf("next = %v, want %v", next, want)
+	}
+
+	info := e.info(nil)
+	if info.Location != "CST" || info.Missed != "skip" {
+		t.Fatalf("info = %+v", info)
+	}
+}
+
+// writeState 写入状态文件，返回文件路径
+func writeState(t *testing.T, dir string, jobs map[string]time.Time) string {
+	path := filepath.Join(dir, "state.json")
+
+	data, err := json.Marshal(stateFile{Jobs: jobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readState(t *testing.T, path string) map[string]time.Time {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	return file.Jobs
+}
+
+func TestCatchUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 每小时执行一次，停机三个半小时，错过三次
+	now := time.Now().Truncate(time.Second)
+	last := now.Add(-3*time.Hour - 30*time.Minute)
+
+	for _, c := range []struct {
+		missed Missed
+		runs   int
+	}{
+		{MissedSkip, 0},
+		{MissedOnce, 1},
+		{MissedAll, 3},
+	} {
+		t.Run(c.missed.String(), func(t *testing.T) {
+			path := writeState(t, dir, map[string]time.Time{"hourly": last})
+
+			m := New(0)
+			defer m.Stop()
+			if err := m.LoadState(path); err != nil {
+				t.Fatal(err)
+			}
+
+			var runs int32
+			err := m.Add(Job{Name: "hourly", Spec: "@every 1h", Overlap: Skip, Missed: c.missed, Func: func(context.Context) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Start()
+
+			if c.runs > 0 {
+				waitHistory(t, m, "hourly", c.runs)
+			}
+			waitIdle(t, m, "hourly")
+
+			if got := int(atomic.LoadInt32(&runs)); got != c.runs {
+				t.Fatalf("runs = %d, want %d", got, c.runs)
+			}
+
+			// 状态推进到最后一次错过的触发，重启后不会再次补执行
+			want := last.Add(3 * time.Hour)
+			if got := readState(t, path)["hourly"]; !got.Equal(want) {
+				t.Fatalf("state = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCatchUpFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().Truncate(time.Second)
+	last := now.Add(-90 * time.Minute)
+	path := writeState(t, dir, map[string]time.Time{"hourly": last})
+
+	// 每次启动相当于一次重启，补执行失败时状态不推进，下次启动再次补执行
+	for i, fail := range []bool{true, false} {
+		m := New(0)
+		if err := m.LoadState(path); err != nil {
+			t.Fatal(err)
+		}
+
+		err := m.Add(Job{Name: "hourly", Spec: "@every 1h", Missed: MissedOnce, Func: func(context.Context) error {
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Start()
+
+		records := waitHistory(t, m, "hourly", 1)
+		waitIdle(t, m, "hourly")
+		m.Stop()
+
+		want, status := last, StatusFailed
+		if !fail {
+			want, status = last.Add(time.Hour), StatusOK
+		}
+		if records[0].Status != status {
+			t.Fatalf("run %d: status = %v, want %v", i, records[0].Status, status)
+		}
+		if got := readState(t, path)["hourly"]; !got.Equal(want) {
+			t.Fatalf("run %d: state = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestStatePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	// 触发时间由测试控制，不依赖真实时间的推进
+	var (
+		mu    sync.Mutex
+		start = time.Date(2018, 8, 2, 10, 0, 0, 0, time.Local)
+		now   = start
+	)
+	m := New(0)
+	defer m.Stop()
+	m.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	if err := m.LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(Job{Name: "daily", Spec: "0 0 19 * * *", Missed: MissedOnce, Func: nop}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一次启动时没有记录，以启动时间为起点，不补执行
+	m.Start()
+	if got, ok := readState(t, path)["daily"]; !ok || !got.Equal(start) {
+		t.Fatalf("state = %v, %v, want baseline %v", got, ok, start)
+	}
+	if records, _ := m.History("daily"); len(records) != 0 {
+		t.Fatalf("got %d records, want 0", len(records))
+	}
+
+	// 暂停期间的触发同样记录
+	if err := m.Pause("daily"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	now = start.Add(9 * time.Hour)
+	mu.Unlock()
+	fire(t, m, "daily")
+	if got := readState(t, path)["daily"]; !got.Equal(start.Add(9 * time.Hour)) {
+		t.Fatalf("state = %v, want %v", got, start.Add(9*time.Hour))
+	}
+	if records, _ := m.History("daily"); len(records) != 0 {
+		t.Fatalf("paused job ran %d times", len(records))
+	}
+	if err := m.StateErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove("daily"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readState(t, path)["daily"]; ok {
+		t.Fatal("state of removed job still exists")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/25        Feng Yifei
+ */
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TechCatsLab/gosnippet/samples/productions/internal/atomicfile"
+)
+
+// checkpointFile 检查点文件的内容
+type checkpointFile struct {
+	Offset uint64    `json:"offset"`
+	Time   time.Time `json:"time"`
+}
+
+// LoadCheckpoint 读取检查点文件中已提交的来源偏移量，文件不存在时返回 0
+func LoadCheckpoint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var file checkpointFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return 0, err
+	}
+	return file.Offset, nil
+}
+
+// Checkpoint 跟踪已完成的序号，并把连续完成的最大偏移量定期写入检查点文件。
+// 偏移量 n 表示序号小于 n 的数据都已保存、丢弃或写入死信，从 n 开始恢复即可保证每条数据至少被处理一次。
+type Checkpoint struct {
+	path  string
+	flush func() error
+	clock Clock
+
+	mu     sync.Mutex
+	offset uint64
+	done   map[uint64]struct{} // 已完成但尚未连续的序号
+	dirty  bool                // offset 变化后尚未保存
+}
+
+// NewCheckpoint 创建从 offset 开始跟踪的检查点。
+// flush 在每次写入检查点文件之前调用，通常为 Sink.Flush，保证已提交的记录确实已经写出，可以为 nil。
+func NewCheckpoint(path string, offset uint64, flush func() error) *Checkpoint {
+	return &Checkpoint{
+		path:   path,
+		flush:  flush,
+		clock:  SystemClock,
+		offset: offset,
+		done:   make(map[uint64]struct{}),
+	}
+}
+
+// Commit 标记序号 seq 的数据已完成
+func (c *Checkpoint) Commit(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seq < c.offset {
+		return
+	}
+
+	c.done[seq] = struct{}{}
+	for {
+		if _, ok := c.done[c.offset]; !ok {
+			break
+		}
+		delete(c.done, c.offset)
+		c.offset++
+		c.dirty = true
+	}
+}
+
+// Offset 返回连续完成的偏移量
+func (c *Checkpoint) Offset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+// Save 将当前偏移量写入检查点文件。先记录偏移量并调用 flush，
+// 再写入同目录下的临时文件并同步到磁盘，最后通过 rename 替换原文件，保证文件内容始终完整。
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	offset, dirty := c.offset, c.dirty
+	c.dirty = false
+	c.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	var err error
+	if c.flush != nil {
+		err = c.flush()
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(checkpointFile{Offset: offset, Time: c.clock.Now()})
+	}
+	if err == nil {
+		err = atomicfile.WriteFile(c.path, data)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Remove 删除检查点文件，批处理全部完成后调用，下一次从头开始
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SetClock 设置写入检查点与定期保存使用的时钟，必须在 Run 之前调用
+func (c *Checkpoint) SetClock(clock Clock) {
+	c.clock = clockOr(clock)
+}
+
+// Run 每隔 interval 保存一次检查点，ctx 结束时再保存一次后返回
+func (c *Checkpoint) Run(ctx context.Context, interval time.Duration) error {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := c.Save(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return c.Save()
+		}
+	}
+}
+
+// Skip 从 src 中读取并丢弃 n 条记录，用于从检查点恢复
+func Skip[T any](src Source[T], n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := src.Read(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
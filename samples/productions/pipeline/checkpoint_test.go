@@ -0,0 +1,218 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/25        Feng Yifei
+ */
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "batch.checkpoint")
+	if offset, err := LoadCheckpoint(path); err != nil || offset != 0 {
+		t.Fatalf("LoadCheckpoint = (%d, %v), want (0, nil)", offset, err)
+	}
+
+	flushed := 0
+	c := NewCheckpoint(path, 0, func() error {
+		flushed++
+		return nil
+	})
+	for _, seq := range []uint64{1, 0, 3, 4} {
+		c.Commit(seq)
+	}
+	if c.Offset() != 2 {
+		t.Fatalf("Offset = %d, want 2", c.Offset())
+	}
+
+	if err = c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := LoadCheckpoint(path); err != nil || offset != 2 {
+		t.Fatalf("LoadCheckpoint = (%d, %v), want (2, nil)", offset, err)
+	}
+
+	c.Commit(2)
+	if err = c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, _ := LoadCheckpoint(path); offset != 5 {
+		t.Fatalf("LoadCheckpoint = %d, want 5", offset)
+	}
+
+	if flushed != 2 {
+		t.Fatalf("flushed %d times, want 2", flushed)
+	}
+
+	// 临时文件必须在 rename 后消失
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	if err = c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := LoadCheckpoint(path); err != nil || offset != 0 {
+		t.Fatalf("LoadCheckpoint after Remove = (%d, %v), want (0, nil)", offset, err)
+	}
+}
+
+// TestResume 模拟批处理在中途崩溃后从检查点恢复，每条数据至少被保存一次
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "batch.checkpoint")
+	records := make([]int, 100)
+	for i := range records {
+		records[i] = i
+	}
+
+	saved := make(map[int]int)
+	errCrash := errors.New("crash")
+
+	run := func(crashAt int) {
+		offset, err := LoadCheckpoint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := NewMemorySource(records)
+		if err = Skip[int](src, offset); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewCheckpoint(path, offset, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := make(chan int)
+		go Feed[int](ctx, src, in)
+
+		stage := Stage[int, int]{
+			Workers: 4,
+			Handle: func(v int) (int, error) {
+				if v%10 == 9 {
+					return 0, ErrDrop
+				}
+				return v, nil
+			},
+			Discard: func(v int, err error) { c.Commit(uint64(v)) },
+		}
+
+		Save(ctx, Run(ctx, in, stage), func(v int) error {
+			if v == crashAt {
+				cancel()
+				return errCrash
+			}
+			saved[v]++
+			return nil
+		}, SinkPolicy{Done: func(v interface{}, err error) {
+			if err == nil {
+				c.Commit(uint64(v.(int)))
+			}
+		}})
+
+		if err = c.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(57)
+	run(-1)
+
+	for i := 0; i < 100; i++ {
+		if i%10 == 9 {
+			continue
+		}
+		if saved[i] < 1 {
+			t.Fatalf("record %d was never saved", i)
+		}
+	}
+	if offset, _ := LoadCheckpoint(path); offset != 100 {
+		t.Fatalf("offset = %d, want 100", offset)
+	}
+}
+
+func TestCheckpointRunFakeClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "batch.checkpoint")
+	flushed := make(chan struct{}, 10)
+	c := NewCheckpoint(path, 0, func() error {
+		flushed <- struct{}{}
+		return nil
+	})
+
+	clock := NewFakeClock(epoch)
+	c.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx, time.Second)
+	}()
+
+	clock.BlockUntil(1)
+	c.Commit(0)
+	c.Commit(1)
+	clock.Advance(time.Second)
+	<-flushed
+
+	// 偏移量没有变化，结束时不再写入
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) != 0 {
+		t.Fatal("checkpoint saved again without new commits")
+	}
+	if offset, err := LoadCheckpoint(path); err != nil || offset != 2 {
+		t.Fatalf("LoadCheckpoint = (%d, %v), want (2, nil)", offset, err)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/27        Feng Yifei
+ */
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时钟，流水线中的等待、超时与计时都通过 Clock 完成，测试时可以替换为 FakeClock
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer 与 time.Timer 相同，通道通过 C() 获取
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker 与 time.Ticker 相同，通道通过 C() 获取
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock 使用 time 包的系统时钟，Clock 为 nil 时使用
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clockOr 返回 c，c 为 nil 时返回 SystemClock
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// FakeClock 只在调用 Advance 时前进的时钟，用于测试。
+// 到期的 Timer 与 Ticker 在 Advance 中按到期时间依次触发，通道已满时丢弃本次触发，与 time 包一致。
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  map[*fakeTimer]struct{} // 尚未触发或停止的 Timer 与 Ticker
+	changed chan struct{}           // timers 变化时关闭
+}
+
+// NewFakeClock 创建从 now 开始的 FakeClock
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		timers:  make(map[*fakeTimer]struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Now 返回当前时间
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer 创建在 d 之后触发的 Timer
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker 创建每隔 d 触发一次的 Ticker，d 必须大于 0
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("pipeline: non-positive interval for NewTicker")
+	}
+
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// Advance 将时钟前进 d，并触发这段时间内到期的 Timer 与 Ticker
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		due := make([]*fakeTimer, 0, len(c.timers))
+		for t := range c.timers {
+			if !t.when.After(end) {
+				due = append(due, t)
+			}
+		}
+		if len(due) == 0 {
+			break
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+
+		t := due[0]
+		c.now = t.when
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			c.remove(t)
+		}
+	}
+	c.now = end
+}
+
+// Timers 返回尚未触发或停止的 Timer 与 Ticker 数量
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil 阻塞直到尚未触发或停止的 Timer 与 Ticker 数量为 n，
+// 用于确认被测试的 goroutine 已经开始等待，之后再调用 Advance
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == n {
+			c.mu.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		<-changed
+	}
+}
+
+// add 与 remove 调用方需持有 c.mu
+func (c *FakeClock) add(t *fakeTimer) {
+	c.timers[t] = struct{}{}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *FakeClock) remove(t *fakeTimer) {
+	delete(c.timers, t)
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// fakeTimer FakeClock 的 Timer 与 Ticker，period 大于 0 时为 Ticker
+type fakeTimer struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	if active {
+		t.clock.remove(t)
+	}
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, active := t.clock.timers[t]
+	if active {
+		t.clock.remove(t)
+	}
+	t.when = t.clock.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+		return active
+	}
+	t.clock.add(t)
+	return active
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
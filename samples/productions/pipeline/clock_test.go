@@ -0,0 +1,140 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/27        Feng Yifei
+ */
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2018, 7, 27, 0, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	timer := clock.NewTimer(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	if fired(timer.C()) {
+		t.Fatal("timer fired early")
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case now := <-timer.C():
+		if !now.Equal(epoch.Add(time.Second)) {
+			t.Fatalf("fired at %v", now)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+
+	if timer.Stop() {
+		t.Fatal("Stop of fired timer returned true")
+	}
+	if clock.Timers() != 0 {
+		t.Fatalf("%d timers left", clock.Timers())
+	}
+	if !clock.Now().Equal(epoch.Add(time.Second)) {
+		t.Fatalf("now = %v", clock.Now())
+	}
+}
+
+func TestFakeClockStopReset(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	timer := clock.NewTimer(time.Second)
+
+	if !timer.Stop() {
+		t.Fatal("Stop of active timer returned false")
+	}
+	clock.Advance(time.Hour)
+	if fired(timer.C()) {
+		t.Fatal("stopped timer fired")
+	}
+
+	if timer.Reset(time.Minute) {
+		t.Fatal("Reset of stopped timer returned true")
+	}
+	clock.Advance(time.Minute)
+	if !fired(timer.C()) {
+		t.Fatal("reset timer did not fire")
+	}
+
+	timer.Reset(0)
+	if !fired(timer.C()) {
+		t.Fatal("zero duration timer did not fire immediately")
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ticker := clock.NewTicker(time.Second)
+
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		if now := <-ticker.C(); !now.Equal(epoch.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("tick %d at %v", i, now)
+		}
+	}
+
+	// 通道已满时丢弃多余的触发
+	clock.Advance(5 * time.Second)
+	<-ticker.C()
+	if fired(ticker.C()) {
+		t.Fatal("ticker delivered more than one pending tick")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	if fired(ticker.C()) || clock.Timers() != 0 {
+		t.Fatal("stopped ticker still active")
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		<-clock.NewTimer(time.Second).C()
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-done
+}
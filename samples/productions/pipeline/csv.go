@@ -0,0 +1,155 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/19        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CSVCodec 描述记录与 CSV 行之间的转换方式
+type CSVCodec[T any] struct {
+	// 表头，为空时不读写表头行
+	Header []string
+	// 将记录编码为一行
+	Encode func(T) ([]string, error)
+	// 将一行解码为记录
+	Decode func([]string) (T, error)
+}
+
+// CSVSource 从 CSV 读取记录
+type CSVSource[T any] struct {
+	r      io.Reader
+	reader *csv.Reader
+	codec  CSVCodec[T]
+	header bool
+	line   int
+}
+
+// NewCSVSource 创建 CSV 来源，r 实现 io.Closer 时由 Close 负责关闭
+func NewCSVSource[T any](r io.Reader, codec CSVCodec[T]) *CSVSource[T] {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(codec.Header)
+
+	return &CSVSource[T]{
+		r:      r,
+		reader: reader,
+		codec:  codec,
+		header: len(codec.Header) > 0,
+	}
+}
+
+// Read 读取下一条记录
+func (s *CSVSource[T]) Read() (T, error) {
+	var v T
+
+	if s.header {
+		s.header = false
+		s.line++
+		if _, err := s.reader.Read(); err != nil {
+			return v, err
+		}
+	}
+
+	row, err := s.reader.Read()
+	if err != nil {
+		return v, err
+	}
+	s.line++
+
+	v, err = s.codec.Decode(row)
+	if err != nil {
+		return v, fmt.Errorf("pipeline: csv line %d: %v", s.line, err)
+	}
+	return v, nil
+}
+
+// Close 关闭底层的 io.Reader
+func (s *CSVSource[T]) Close() error {
+	return closeIfCloser(s.r)
+}
+
+// CSVSink 将记录写为 CSV
+type CSVSink[T any] struct {
+	mu     sync.Mutex
+	w      io.Writer
+	writer *csv.Writer
+	codec  CSVCodec[T]
+	header bool
+}
+
+// NewCSVSink 创建 CSV 去向，w 实现 io.Closer 时由 Close 负责关闭
+func NewCSVSink[T any](w io.Writer, codec CSVCodec[T]) *CSVSink[T] {
+	return &CSVSink[T]{
+		w:      w,
+		writer: csv.NewWriter(w),
+		codec:  codec,
+		header: len(codec.Header) > 0,
+	}
+}
+
+// Write 写入一条记录
+func (s *CSVSink[T]) Write(v T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.header {
+		s.header = false
+		if err := s.writer.Write(s.codec.Header); err != nil {
+			return err
+		}
+	}
+
+	row, err := s.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return s.writer.Write(row)
+}
+
+// Flush 刷新缓冲
+func (s *CSVSink[T]) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// Close 刷新缓冲并关闭底层的 io.Writer
+func (s *CSVSink[T]) Close() error {
+	if err := s.Flush(); err != nil {
+		closeIfCloser(s.w)
+		return err
+	}
+	return closeIfCloser(s.w)
+}
@@ -0,0 +1,149 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/19        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirSource 从目录中的 JSON 文件读取记录，每个 *.json 文件一条记录，按文件名顺序读取
+type DirSource[T any] struct {
+	files []string
+	next  int
+}
+
+// NewDirSource 创建目录来源
+func NewDirSource[T any](dir string) (*DirSource[T], error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	return &DirSource[T]{files: files}, nil
+}
+
+// Read 读取下一条记录
+func (s *DirSource[T]) Read() (T, error) {
+	var v T
+	if s.next >= len(s.files) {
+		return v, io.EOF
+	}
+
+	file := s.files[s.next]
+	s.next++
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return v, err
+	}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("pipeline: %s: %v", file, err)
+	}
+	return v, nil
+}
+
+// Close 无需释放任何资源
+func (s *DirSource[T]) Close() error {
+	return nil
+}
+
+// DirSink 将每条记录写为目录中的一个 JSON 文件
+type DirSink[T any] struct {
+	dir   string
+	name  func(T) string
+	count int
+}
+
+// NewDirSink 创建目录去向，目录不存在时自动创建。
+// name 返回记录对应的文件名（不含扩展名），为 nil 时按写入顺序编号；
+// 文件名包含路径分隔符或为 "."、".." 时 Write 返回错误；
+// 同名文件已经存在时 Write 同样返回错误，不会覆盖之前写入的记录。
+func NewDirSink[T any](dir string, name func(T) string) (*DirSink[T], error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DirSink[T]{dir: dir, name: name}, nil
+}
+
+// Write 写入一条记录
+func (s *DirSink[T]) Write(v T) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	name := fmt.Sprintf("%08d", s.count)
+	if s.name != nil {
+		name = s.name(v)
+	}
+	if !validName(name) {
+		return fmt.Errorf("pipeline: invalid file name %q", name)
+	}
+
+	path := filepath.Join(s.dir, name+".json")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("pipeline: file %s already exists: %w", path, os.ErrExist)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// validName 文件名由记录内容决定，不能包含路径分隔符或为 "."、".."，以免写到目录之外
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`+string(filepath.Separator))
+}
+
+// Flush 每条记录写入时已经落盘，无需刷新
+func (s *DirSink[T]) Flush() error {
+	return nil
+}
+
+// Close 无需释放任何资源
+func (s *DirSink[T]) Close() error {
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/24        Feng Yifei
+ */
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retry 单条数据的重试策略
+type Retry struct {
+	// 总尝试次数，小于 1 时按 1 处理
+	Attempts int
+	// 第一次重试前的等待时间，之后每次翻倍
+	Backoff time.Duration
+	// 等待时间的上限，为 0 时不限制
+	MaxBackoff time.Duration
+	// 等待使用的时钟，为 nil 时使用 SystemClock
+	Clock Clock
+}
+
+// Do 执行 fn，失败时按策略重试，返回实际尝试的次数与最后一次的错误。
+// fn 返回 ErrDrop 时不再重试；还有剩余次数时 ctx 结束则返回 ctx.Err()，
+// 表示该数据没有处理完成，调用方不应把它当作最终失败。
+func (r Retry) Do(ctx context.Context, fn func() error) (int, error) {
+	backoff := r.Backoff
+
+	attempts := 0
+	for {
+		attempts++
+		err := fn()
+		if err == nil || err == ErrDrop || attempts >= r.Attempts {
+			return attempts, err
+		}
+
+		if backoff > 0 {
+			timer := clockOr(r.Clock).NewTimer(backoff)
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+				timer.Stop()
+				return attempts, ctx.Err()
+			}
+
+			backoff *= 2
+			if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		} else if ctx.Err() != nil {
+			return attempts, ctx.Err()
+		}
+	}
+}
+
+// Failure 处理或保存失败的数据
+type Failure struct {
+	// 失败的阶段名称
+	Stage string `json:"stage"`
+	// 失败的数据
+	Record interface{} `json:"record"`
+	// 最后一次失败的错误
+	Err   error  `json:"-"`
+	Error string `json:"error"`
+	// 尝试的次数
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+
+	// 不为 nil 时表示 Flush 的请求，写出之前的数据后回复写入错误
+	ack chan error
+}
+
+// canceled 判断 err 是否为 Retry.Do 因 ctx 结束而返回的错误
+func canceled(ctx context.Context, err error) bool {
+	return err != nil && err == ctx.Err()
+}
+
+// sendFailure 将失败的数据发送到 errs，errs 为 nil 时直接丢弃。
+// 发送前 ctx 结束时返回 false，此时数据既没有写入死信，也不能提交检查点
+func sendFailure(ctx context.Context, errs chan<- Failure, now time.Time, stage string, record interface{}, attempts int, err error) bool {
+	if errs == nil {
+		return true
+	}
+
+	f := Failure{
+		Stage:    stage,
+		Record:   record,
+		Err:      err,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     now,
+	}
+
+	select {
+	case errs <- f:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Summary 失败数据的汇总
+type Summary struct {
+	Total   int
+	ByStage map[string]int
+}
+
+func (s Summary) String() string {
+	stages := make([]string, 0, len(s.ByStage))
+	for stage := range s.ByStage {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	for i, stage := range stages {
+		stages[i] = fmt.Sprintf("%s=%d", stage, s.ByStage[stage])
+	}
+	return fmt.Sprintf("failed=%d [%s]", s.Total, strings.Join(stages, " "))
+}
+
+// DeadLetter 死信队列：各阶段把最终失败的数据发送到 C()，
+// 后台 goroutine 将其连同错误与阶段名称以 JSON Lines 格式写入 w
+type DeadLetter struct {
+	c    chan Failure
+	done chan struct{}
+	w    io.Writer
+	err  error
+
+	mu      sync.Mutex
+	summary Summary
+
+	closeMu sync.Mutex
+	closed  bool
+}
+
+// NewDeadLetter 创建死信队列，buffer 为通道的缓冲大小
+func NewDeadLetter(w io.Writer, buffer int) *DeadLetter {
+	d := &DeadLetter{
+		c:       make(chan Failure, buffer),
+		done:    make(chan struct{}),
+		w:       w,
+		summary: Summary{ByStage: make(map[string]int)},
+	}
+
+	go d.collect()
+	return d
+}
+
+// C 返回接收失败数据的通道，可以赋值给 Stage.Errors 与 SinkPolicy.Errors
+func (d *DeadLetter) C() chan<- Failure {
+	return d.c
+}
+
+func (d *DeadLetter) collect() {
+	defer close(d.done)
+
+	encoder := json.NewEncoder(d.w)
+	for f := range d.c {
+		if f.ack != nil {
+			if w, ok := d.w.(interface{ Flush() error }); ok && d.err == nil {
+				d.err = w.Flush()
+			}
+			f.ack <- d.err
+			continue
+		}
+
+		d.mu.Lock()
+		d.summary.Total++
+		d.summary.ByStage[f.Stage]++
+		d.mu.Unlock()
+
+		if d.err == nil {
+			d.err = encoder.Encode(f)
+		}
+	}
+}
+
+// Summary 返回当前的汇总结果
+func (d *DeadLetter) Summary() Summary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	summary := Summary{Total: d.summary.Total, ByStage: make(map[string]int, len(d.summary.ByStage))}
+	for stage, n := range d.summary.ByStage {
+		summary.ByStage[stage] = n
+	}
+	return summary
+}
+
+// Close 关闭通道并等待所有失败数据写入完成，返回写入过程中遇到的第一个错误。
+// 只能在所有向 C() 发送数据的阶段都退出后调用。
+func (d *DeadLetter) Close() error {
+	d.closeMu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.c)
+	}
+	d.closeMu.Unlock()
+
+	<-d.done
+	return d.err
+}
+
+// Flush 等待在此之前发送到 C() 的失败数据全部写入，w 实现了 Flush 时同时刷新 w，
+// 返回写入过程中遇到的第一个错误。提交检查点前调用，保证已提交的失败数据确实已经写出
+func (d *DeadLetter) Flush() error {
+	ack := make(chan error, 1)
+
+	d.closeMu.Lock()
+	if d.closed {
+		d.closeMu.Unlock()
+		<-d.done
+		return d.err
+	}
+	d.c <- Failure{ack: ack}
+	d.closeMu.Unlock()
+
+	return <-ack
+}
@@ -0,0 +1,214 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/24        Feng Yifei
+ */
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	errTemporary := errors.New("temporary")
+
+	calls := 0
+	attempts, err := Retry{Attempts: 5, Backoff: time.Microsecond, MaxBackoff: 4 * time.Microsecond}.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errTemporary
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Do = (%d, %v), want (3, nil)", attempts, err)
+	}
+
+	attempts, err = Retry{Attempts: 2}.Do(context.Background(), func() error { return errTemporary })
+	if err != errTemporary || attempts != 2 {
+		t.Fatalf("Do = (%d, %v), want (2, %v)", attempts, err, errTemporary)
+	}
+
+	attempts, err = Retry{Attempts: 5}.Do(context.Background(), func() error { return ErrDrop })
+	if err != ErrDrop || attempts != 1 {
+		t.Fatalf("Do = (%d, %v), want (1, %v)", attempts, err, ErrDrop)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	var buf bytes.Buffer
+	dead := NewDeadLetter(&buf, 4)
+
+	flaky := 0
+	stage := Stage[int, int]{
+		Name:    "handle",
+		Workers: 2,
+		Retry:   Retry{Attempts: 2},
+		Errors:  dead.C(),
+		Handle: func(v int) (int, error) {
+			switch {
+			case v == 3:
+				return 0, errors.New("bad record")
+			case v == 5:
+				return 0, ErrDrop
+			}
+			return v, nil
+		},
+	}
+
+	report := Save(context.Background(), Run(context.Background(), source(10), stage), func(v int) error {
+		if v == 7 {
+			flaky++
+			if flaky == 1 {
+				return errors.New("flaky")
+			}
+		}
+		if v == 9 {
+			return errors.New("disk full")
+		}
+		return nil
+	}, SinkPolicy{Retry: Retry{Attempts: 2}, Errors: dead.C()})
+
+	if err := dead.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Saved != 7 || report.Dropped != 1 || report.Retried != 2 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+
+	summary := dead.Summary()
+	if summary.Total != 2 || summary.ByStage["handle"] != 1 || summary.ByStage["save"] != 1 {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+
+	decoder := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var f struct {
+			Stage    string `json:"stage"`
+			Record   int    `json:"record"`
+			Error    string `json:"error"`
+			Attempts int    `json:"attempts"`
+		}
+		if err := decoder.Decode(&f); err != nil {
+			t.Fatal(err)
+		}
+
+		switch f.Stage {
+		case "handle":
+			if f.Record != 3 || f.Error != "bad record" || f.Attempts != 2 {
+				t.Errorf("unexpected failure: %+v", f)
+			}
+		case "save":
+			if f.Record != 9 || f.Error != "disk full" || f.Attempts != 2 {
+				t.Errorf("unexpected failure: %+v", f)
+			}
+		default:
+			t.Errorf("unexpected stage %q", f.Stage)
+		}
+	}
+}
+
+func TestRetryFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	r := Retry{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second, Clock: clock}
+
+	type result struct {
+		attempts int
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		attempts, err := r.Do(context.Background(), func() error { return errors.New("temporary") })
+		done <- result{attempts, err}
+	}()
+
+	// 等待时间依次为 1s、2s，第三次被 MaxBackoff 限制为 3s
+	for _, d := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		clock.BlockUntil(1)
+		clock.Advance(d - time.Nanosecond)
+		clock.BlockUntil(1)
+		clock.Advance(time.Nanosecond)
+	}
+
+	if res := <-done; res.attempts != 4 || res.err == nil {
+		t.Fatalf("Do = (%d, %v)", res.attempts, res.err)
+	}
+}
+
+func TestRetryCanceledFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	type result struct {
+		attempts int
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		attempts, err := Retry{Attempts: 3, Backoff: time.Second, Clock: clock}.Do(ctx, func() error { return errors.New("temporary") })
+		done <- result{attempts, err}
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+	// 中断的重试返回 ctx.Err()，而不是上一次的错误
+	if res := <-done; res.attempts != 1 || res.err != context.Canceled {
+		t.Fatalf("Do = (%d, %v), want (1, %v)", res.attempts, res.err, context.Canceled)
+	}
+	clock.BlockUntil(0)
+}
+
+func TestDeadLetterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	dead := NewDeadLetter(w, 4)
+
+	dead.C() <- Failure{Stage: "save", Record: 1, Error: "disk full"}
+	if err := dead.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "disk full") {
+		t.Fatalf("failure not written after Flush: %q", buf.String())
+	}
+	if summary := dead.Summary(); summary.Total != 1 {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+
+	if err := dead.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dead.Flush(); err != nil {
+		t.Fatalf("Flush after Close = %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/19        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSONLinesSource 从 JSON Lines 读取记录，每行一个 JSON 对象
+type JSONLinesSource[T any] struct {
+	r       io.Reader
+	decoder *json.Decoder
+}
+
+// NewJSONLinesSource 创建 JSON Lines 来源，r 实现 io.Closer 时由 Close 负责关闭
+func NewJSONLinesSource[T any](r io.Reader) *JSONLinesSource[T] {
+	return &JSONLinesSource[T]{
+		r:       r,
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// Read 读取下一条记录
+func (s *JSONLinesSource[T]) Read() (T, error) {
+	var v T
+	err := s.decoder.Decode(&v)
+	return v, err
+}
+
+// Close 关闭底层的 io.Reader
+func (s *JSONLinesSource[T]) Close() error {
+	return closeIfCloser(s.r)
+}
+
+// JSONLinesSink 将记录写为 JSON Lines
+type JSONLinesSink[T any] struct {
+	mu      sync.Mutex
+	w       io.Writer
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONLinesSink 创建 JSON Lines 去向，w 实现 io.Closer 时由 Close 负责关闭
+func NewJSONLinesSink[T any](w io.Writer) *JSONLinesSink[T] {
+	buf := bufio.NewWriter(w)
+
+	return &JSONLinesSink[T]{
+		w:       w,
+		buf:     buf,
+		encoder: json.NewEncoder(buf),
+	}
+}
+
+// Write 写入一条记录，json.Encoder 会在每条记录后追加换行
+func (s *JSONLinesSink[T]) Write(v T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(v)
+}
+
+// Flush 刷新缓冲
+func (s *JSONLinesSink[T]) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Flush()
+}
+
+// Close 刷新缓冲并关闭底层的 io.Writer
+func (s *JSONLinesSink[T]) Close() error {
+	if err := s.Flush(); err != nil {
+		closeIfCloser(s.w)
+		return err
+	}
+	return closeIfCloser(s.w)
+}
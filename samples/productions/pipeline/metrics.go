@@ -0,0 +1,276 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/26        Feng Yifei
+ */
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples 每个阶段保留的最近延迟样本数量，用于计算分位数
+const latencySamples = 1024
+
+// StageMetrics 单个阶段的计数、队列深度与处理延迟。所有方法都可以在 nil 上调用，此时不做任何事
+type StageMetrics struct {
+	name  string
+	clock Clock
+	start time.Time
+
+	in, out, dropped, failed int64
+
+	mu      sync.Mutex
+	queue   func() int
+	tickets *Tickets
+	samples [latencySamples]time.Duration
+	count   int
+}
+
+// StageSnapshot 阶段在某一时刻的统计数据
+type StageSnapshot struct {
+	Name string
+	// 读入、发送到下游、主动丢弃、最终失败的记录数
+	In, Out, Dropped, Failed int64
+	// 输入通道中等待处理的记录数
+	Queue int
+	// 使用中的票数与票池容量，未关联票池时为 0
+	TicketsInUse, TicketsTotal int
+	// 自开始以来平均每秒发送到下游的记录数
+	Rate float64
+	// 最近 latencySamples 条记录处理耗时的分位数
+	P50, P99 time.Duration
+}
+
+// IncIn 读入一条记录
+func (m *StageMetrics) IncIn() {
+	if m != nil {
+		atomic.AddInt64(&m.in, 1)
+	}
+}
+
+// IncOut 向下游发送一条记录
+func (m *StageMetrics) IncOut() {
+	if m != nil {
+		atomic.AddInt64(&m.out, 1)
+	}
+}
+
+// IncDropped 主动丢弃一条记录
+func (m *StageMetrics) IncDropped() {
+	if m != nil {
+		atomic.AddInt64(&m.dropped, 1)
+	}
+}
+
+// IncFailed 一条记录最终处理失败
+func (m *StageMetrics) IncFailed() {
+	if m != nil {
+		atomic.AddInt64(&m.failed, 1)
+	}
+}
+
+// Observe 记录一次处理耗时
+func (m *StageMetrics) Observe(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.samples[m.count%latencySamples] = d
+	m.count++
+	m.mu.Unlock()
+}
+
+// WatchQueue 设置读取队列深度的函数，通常返回输入通道的 len
+func (m *StageMetrics) WatchQueue(queue func() int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.queue = queue
+	m.mu.Unlock()
+}
+
+// WatchTickets 关联阶段使用的票池
+func (m *StageMetrics) WatchTickets(t *Tickets) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.tickets = t
+	m.mu.Unlock()
+}
+
+// Snapshot 返回当前的统计数据，m 为 nil 时返回零值
+func (m *StageMetrics) Snapshot() StageSnapshot {
+	if m == nil {
+		return StageSnapshot{}
+	}
+
+	s := StageSnapshot{
+		Name:    m.name,
+		In:      atomic.LoadInt64(&m.in),
+		Out:     atomic.LoadInt64(&m.out),
+		Dropped: atomic.LoadInt64(&m.dropped),
+		Failed:  atomic.LoadInt64(&m.failed),
+	}
+
+	if elapsed := m.clock.Now().Sub(m.start).Seconds(); elapsed > 0 {
+		s.Rate = float64(s.Out) / elapsed
+	}
+
+	m.mu.Lock()
+	queue, tickets := m.queue, m.tickets
+	n := m.count
+	if n > latencySamples {
+		n = latencySamples
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, m.samples[:n])
+	m.mu.Unlock()
+
+	if queue != nil {
+		s.Queue = queue()
+	}
+	if tickets != nil {
+		s.TicketsInUse = tickets.InUse()
+		s.TicketsTotal = tickets.Total()
+	}
+
+	if n > 0 {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		s.P50 = samples[(n-1)*50/100]
+		s.P99 = samples[(n-1)*99/100]
+	}
+	return s
+}
+
+func (s StageSnapshot) String() string {
+	line := fmt.Sprintf("%s in=%d out=%d q=%d", s.Name, s.In, s.Out, s.Queue)
+	if s.Dropped > 0 {
+		line += fmt.Sprintf(" drop=%d", s.Dropped)
+	}
+	if s.Failed > 0 {
+		line += fmt.Sprintf(" fail=%d", s.Failed)
+	}
+	if s.TicketsTotal > 0 {
+		line += fmt.Sprintf(" tickets=%d/%d", s.TicketsInUse, s.TicketsTotal)
+	}
+	line += fmt.Sprintf(" %.0f/s", s.Rate)
+	if s.P99 > 0 {
+		line += fmt.Sprintf(" p50=%v p99=%v", s.P50, s.P99)
+	}
+	return line
+}
+
+// Metrics 流水线中所有阶段的统计数据
+type Metrics struct {
+	mu     sync.Mutex
+	clock  Clock
+	stages []*StageMetrics
+}
+
+// NewMetrics 创建空的统计
+func NewMetrics() *Metrics {
+	return &Metrics{clock: SystemClock}
+}
+
+// SetClock 设置计算速率与刷新进度使用的时钟，必须在创建阶段统计之前调用
+func (m *Metrics) SetClock(clock Clock) {
+	m.mu.Lock()
+	m.clock = clockOr(clock)
+	m.mu.Unlock()
+}
+
+// Stage 返回名为 name 的阶段统计，不存在时创建，阶段按创建顺序排列
+func (m *Metrics) Stage(name string) *StageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.stages {
+		if s.name == name {
+			return s
+		}
+	}
+
+	s := &StageMetrics{name: name, clock: m.clock, start: m.clock.Now()}
+	m.stages = append(m.stages, s)
+	return s
+}
+
+// Snapshot 返回所有阶段当前的统计数据
+func (m *Metrics) Snapshot() []StageSnapshot {
+	m.mu.Lock()
+	stages := make([]*StageMetrics, len(m.stages))
+	copy(stages, m.stages)
+	m.mu.Unlock()
+
+	snapshots := make([]StageSnapshot, len(stages))
+	for i, s := range stages {
+		snapshots[i] = s.Snapshot()
+	}
+	return snapshots
+}
+
+func (m *Metrics) String() string {
+	snapshots := m.Snapshot()
+
+	parts := make([]string, len(snapshots))
+	for i, s := range snapshots {
+		parts[i] = s.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+// Progress 每隔 interval 在 w 的同一行刷新一次所有阶段的统计，ctx 结束时输出最终结果并换行
+func (m *Metrics) Progress(ctx context.Context, w io.Writer, interval time.Duration) {
+	m.mu.Lock()
+	clock := m.clock
+	m.mu.Unlock()
+
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			fmt.Fprintf(w, "\r\033[K%s", m)
+		case <-ctx.Done():
+			fmt.Fprintf(w, "\r\033[K%s\n", m)
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/26        Feng Yifei
+ */
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageMetrics(t *testing.T) {
+	m := NewMetrics()
+
+	errBad := errors.New("bad")
+	s := Stage[int, int]{
+		Name:    "filter",
+		Workers: 4,
+		Metrics: m.Stage("filter"),
+		Handle: func(v int) (int, error) {
+			switch v % 10 {
+			case 0:
+				return 0, ErrDrop
+			case 1:
+				return 0, errBad
+			}
+			return v, nil
+		},
+	}
+
+	if n := Drain(Run(context.Background(), source(100), s)); n != 80 {
+		t.Fatalf("got %d values, want 80", n)
+	}
+
+	snapshots := m.Snapshot()
+	if len(snapshots) != 1 {
+		t.Fatalf("got %d stages, want 1", len(snapshots))
+	}
+
+	got := snapshots[0]
+	if got.Name != "filter" || got.In != 100 || got.Out != 80 || got.Dropped != 10 || got.Failed != 10 {
+		t.Fatalf("snapshot = %+v", got)
+	}
+	if got.Queue != 0 {
+		t.Fatalf("queue = %d, want 0", got.Queue)
+	}
+	if got.Rate <= 0 {
+		t.Fatalf("rate = %v, want > 0", got.Rate)
+	}
+}
+
+func TestStageMetricsLatency(t *testing.T) {
+	m := NewMetrics().Stage("latency")
+	for i := 1; i <= 100; i++ {
+		m.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	s := m.Snapshot()
+	if s.P50 != 50*time.Millisecond || s.P99 != 99*time.Millisecond {
+		t.Fatalf("p50 = %v, p99 = %v", s.P50, s.P99)
+	}
+
+	// 超过样本容量后只保留最近的样本
+	for i := 0; i < latencySamples; i++ {
+		m.Observe(time.Second)
+	}
+	if s := m.Snapshot(); s.P50 != time.Second {
+		t.Fatalf("p50 = %v, want 1s", s.P50)
+	}
+}
+
+func TestStageMetricsGauges(t *testing.T) {
+	m := NewMetrics()
+	if m.Stage("fetch") != m.Stage("fetch") {
+		t.Fatal("Stage returned different metrics for the same name")
+	}
+
+	tickets := NewTickets(4)
+	tickets.TryAcquire()
+
+	queue := make(chan int, 8)
+	queue <- 1
+	queue <- 2
+
+	fetch := m.Stage("fetch")
+	fetch.WatchTickets(tickets)
+	fetch.WatchQueue(func() int { return len(queue) })
+
+	s := fetch.Snapshot()
+	if s.Queue != 2 || s.TicketsInUse != 1 || s.TicketsTotal != 4 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	if line := s.String(); !strings.Contains(line, "tickets=1/4") || !strings.Contains(line, "q=2") {
+		t.Fatalf("line = %q", line)
+	}
+}
+
+func TestStageMetricsNil(t *testing.T) {
+	var m *StageMetrics
+	m.IncIn()
+	m.IncOut()
+	m.IncDropped()
+	m.IncFailed()
+	m.Observe(time.Millisecond)
+	m.WatchQueue(func() int { return 0 })
+	m.WatchTickets(NewTickets(1))
+}
+
+func TestProgress(t *testing.T) {
+	m := NewMetrics()
+	m.Stage("fetch").IncIn()
+	m.Stage("save").IncOut()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	m.Progress(ctx, &buf, time.Hour)
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "\n") || !strings.Contains(out, "fetch in=1") || !strings.Contains(out, " | save in=0 out=1") {
+		t.Fatalf("progress = %q", out)
+	}
+}
+
+func TestMetricsRateFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	m := NewMetrics()
+	m.SetClock(clock)
+
+	save := m.Stage("save")
+	for i := 0; i < 10; i++ {
+		save.IncOut()
+	}
+	clock.Advance(2 * time.Second)
+
+	if rate := save.Snapshot().Rate; rate != 5 {
+		t.Fatalf("rate = %v, want 5", rate)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *StageMetrics
+
+	m.IncIn()
+	m.IncOut()
+	m.Observe(time.Millisecond)
+	m.WatchQueue(func() int { return 1 })
+	if s := m.Snapshot(); s != (StageSnapshot{}) {
+		t.Fatalf("Snapshot of nil metrics = %+v, want zero", s)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/23        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// sequenced 有序模式下在 worker 与重排 goroutine 之间传递的处理结果
+type sequenced[T any] struct {
+	seq     uint64
+	value   T
+	dropped bool
+}
+
+// Window 有序模式的重排窗口。来源在分配序号 seq 之前调用 Wait，
+// 保证 seq 小于 已发送序号+窗口大小，从而限制重排缓冲区中的数据量。
+// 窗口必须在来源处生效：来源之后的乱序（例如每条数据一个发送 goroutine）不受窗口限制。
+type Window struct {
+	mu      sync.Mutex
+	next    uint64
+	size    uint64
+	changed chan struct{} // next 变化时关闭
+}
+
+// NewWindow 创建大小为 size、从序号 0 开始的窗口，size 小于 1 时按 1 处理
+func NewWindow(size int) *Window {
+	return NewWindowAt(size, 0)
+}
+
+// NewWindowAt 创建大小为 size、从序号 next 开始的窗口，用于从检查点恢复
+func NewWindowAt(size int, next uint64) *Window {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Window{
+		next:    next,
+		size:    uint64(size),
+		changed: make(chan struct{}),
+	}
+}
+
+// Wait 阻塞直到 seq 落入窗口内或 ctx 结束
+func (w *Window) Wait(ctx context.Context, seq uint64) error {
+	for {
+		w.mu.Lock()
+		if seq < w.next+w.size {
+			w.mu.Unlock()
+			return nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Next 返回下一个等待发送的序号
+func (w *Window) Next() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.next
+}
+
+func (w *Window) advance(next uint64) {
+	w.mu.Lock()
+	if next != w.next {
+		w.next = next
+		close(w.changed)
+		w.changed = make(chan struct{})
+	}
+	w.mu.Unlock()
+}
+
+// runOrdered 以有序模式运行阶段 s：workers 个 worker 并发处理，
+// 重排 goroutine 缓存先完成的结果，按序号依次写入 out 并推进窗口
+func runOrdered[In, Out any](ctx context.Context, in <-chan In, out chan<- Out, s Stage[In, Out], workers int) {
+	var (
+		results = make(chan sequenced[Out], workers)
+		wg      sync.WaitGroup
+	)
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for v := range in {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				result, err := s.process(ctx, v)
+				results <- sequenced[Out]{seq: s.Seq(v), value: result, dropped: err != nil}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(out)
+
+		var (
+			next    uint64
+			pending = make(map[uint64]sequenced[Out])
+		)
+		if s.Window != nil {
+			next = s.Window.Next()
+		}
+
+		emit := func(r sequenced[Out]) {
+			if r.dropped || ctx.Err() != nil {
+				return
+			}
+			select {
+			case out <- r.value:
+				s.Metrics.IncOut()
+			case <-ctx.Done():
+			}
+		}
+
+		for r := range results {
+			// 序号重复或早于已发送的序号时无法再排序，直接发送
+			if r.seq < next {
+				emit(r)
+				continue
+			}
+
+			pending[r.seq] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				emit(r)
+				next++
+			}
+
+			if s.Window != nil {
+				s.Window.advance(next)
+			}
+		}
+
+		// 来源结束后仍有序号缺失时，按序号顺序发送剩余的结果
+		seqs := make([]uint64, 0, len(pending))
+		for seq := range pending {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+		for _, seq := range seqs {
+			emit(pending[seq])
+		}
+	}()
+}
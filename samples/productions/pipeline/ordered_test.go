@@ -0,0 +1,167 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/23        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// shuffled 以有限的乱序程度发送 0 到 n-1，模拟 goticket 中每条记录一个 goroutine 的发送方式
+func shuffled(n, disorder int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		for base := 0; base < n; base += disorder {
+			end := base + disorder
+			if end > n {
+				end = n
+			}
+			for _, i := range rand.Perm(end - base) {
+				ch <- base + i
+			}
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func TestRunOrdered(t *testing.T) {
+	stage := Stage[int, int]{
+		Workers: 8,
+		Seq:     func(v int) uint64 { return uint64(v) },
+		Handle: func(v int) (int, error) {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			if v%10 == 3 {
+				return 0, ErrDrop
+			}
+			return v, nil
+		},
+	}
+
+	prev, count := -1, 0
+	for v := range Run(context.Background(), shuffled(1000, 16), stage) {
+		if v <= prev {
+			t.Fatalf("got %d after %d", v, prev)
+		}
+		prev = v
+		count++
+	}
+
+	if count != 900 {
+		t.Fatalf("got %d values, want 900", count)
+	}
+}
+
+func TestRunOrderedGap(t *testing.T) {
+	in := make(chan int, 4)
+	in <- 5
+	in <- 2
+	in <- 1
+	in <- 7
+	close(in)
+
+	stage := Stage[int, int]{
+		Seq:    func(v int) uint64 { return uint64(v) },
+		Handle: func(v int) (int, error) { return v, nil },
+	}
+
+	var got []int
+	for v := range Run(context.Background(), in, stage) {
+		got = append(got, v)
+	}
+
+	if len(got) != 4 || got[0] != 1 || got[1] != 2 || got[2] != 5 || got[3] != 7 {
+		t.Fatalf("got %v, want [1 2 5 7]", got)
+	}
+}
+
+func TestRunOrderedWindow(t *testing.T) {
+	const size = 8
+
+	var (
+		window  = NewWindow(size)
+		tickets = NewTickets(4)
+		in      = make(chan int, 16)
+	)
+
+	// 与 goticket 相同，每条数据由单独的 goroutine 发送，发送顺序不确定
+	go func() {
+		for i := 0; i < 500; i++ {
+			window.Wait(context.Background(), uint64(i))
+			tickets.Acquire(context.Background())
+			go func(i int) {
+				in <- i
+				tickets.Release()
+			}(i)
+		}
+		tickets.Wait(context.Background())
+		close(in)
+	}()
+
+	stage := Stage[int, int]{
+		Workers: 4,
+		Seq:     func(v int) uint64 { return uint64(v) },
+		Window:  window,
+		Handle:  func(v int) (int, error) { return v, nil },
+	}
+
+	next := 0
+	for v := range Run(context.Background(), in, stage) {
+		if v != next {
+			t.Fatalf("got %d, want %d", v, next)
+		}
+		next++
+	}
+
+	if next != 500 {
+		t.Fatalf("got %d values, want 500", next)
+	}
+}
+
+func TestRunOrderedCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stage := Stage[int, int]{
+		Workers: 4,
+		Seq:     func(v int) uint64 { return uint64(v) },
+		Handle:  func(v int) (int, error) { return v, nil },
+	}
+
+	// 序号 0 永远不会出现，所有结果都停留在重排缓冲区中，取消后输出通道必须关闭
+	out := Run(ctx, Run(ctx, source(1000), Stage[int, int]{Handle: func(v int) (int, error) { return v + 1, nil }}), stage)
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if n := Drain(out); n != 0 {
+		t.Fatalf("got %d values after cancel, want 0", n)
+	}
+}
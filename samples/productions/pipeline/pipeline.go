@@ -0,0 +1,218 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/16        Feng Yifei
+ */
+
+// Package pipeline 将 goticket 中 PersonHandler.Batch 的处理方式抽象为通用的多阶段流水线：
+// 每个阶段拥有独立的 worker 数量与输出缓冲，阶段之间通过通道扇出、扇入，
+// 源通道关闭后各阶段依次排空并关闭自己的输出通道。
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDrop 由 Handle 返回，表示该条数据被主动丢弃，不再发送到下游
+var ErrDrop = errors.New("pipeline: record dropped")
+
+// Stage 流水线中的一个处理阶段
+type Stage[In, Out any] struct {
+	// 阶段名称
+	Name string
+	// 并发执行 Handle 的 goroutine 数量，小于 1 时按 1 处理
+	Workers int
+	// 输出通道的缓冲大小
+	Buffer int
+	// 处理函数，返回错误时该条数据不会发送到下游
+	Handle func(In) (Out, error)
+	// Handle 失败时的重试策略，返回 ErrDrop 时不重试
+	Retry Retry
+	// 重试后仍然失败的数据发送到 Errors，为 nil 时直接丢弃
+	Errors chan<- Failure
+	// 数据因 ErrDrop 或重试后仍然失败而没有发送到下游时调用，可用于提交检查点；
+	// 因 ctx 结束而中断、或失败数据没能写入 Errors 时不调用
+	Discard func(In, error)
+	// 不为 nil 时启用有序模式：Handle 仍并发执行，但输出按 Seq 返回的序号依次发送。
+	// 序号在来源处分配，从 0（或 Window 的起始序号）开始连续递增
+	Seq func(In) uint64
+	// 有序模式下的重排窗口，由来源与阶段共享；为 nil 时重排缓冲区不限大小
+	Window *Window
+	// 阶段的统计数据，为 nil 时不统计
+	Metrics *StageMetrics
+}
+
+// Flow 表示一段已经组装好的流水线，接收输入通道，返回输出通道
+type Flow[In, Out any] func(ctx context.Context, in <-chan In) <-chan Out
+
+// Flow 将单个阶段转换为 Flow
+func (s Stage[In, Out]) Flow() Flow[In, Out] {
+	return func(ctx context.Context, in <-chan In) <-chan Out {
+		return Run(ctx, in, s)
+	}
+}
+
+// Then 在 f 之后串联阶段 s，两者的类型通过 Mid 衔接
+func Then[In, Mid, Out any](f Flow[In, Mid], s Stage[Mid, Out]) Flow[In, Out] {
+	return func(ctx context.Context, in <-chan In) <-chan Out {
+		return Run(ctx, f(ctx, in), s)
+	}
+}
+
+// Chain 将任意多个输入输出类型相同的阶段依次串联
+func Chain[T any](stages ...Stage[T, T]) Flow[T, T] {
+	return func(ctx context.Context, in <-chan T) <-chan T {
+		out := in
+		for _, s := range stages {
+			out = Run(ctx, out, s)
+		}
+		return out
+	}
+}
+
+// Run 启动阶段 s：Workers 个 goroutine 从 in 读取数据（扇出），处理结果写入同一个输出通道（扇入）。
+// in 关闭且所有 worker 退出后关闭输出通道。
+// ctx 取消后 worker 不再处理数据，但会继续读取并丢弃 in 中的剩余数据，直到 in 关闭，
+// 以免上游发送方永久阻塞。
+func Run[In, Out any](ctx context.Context, in <-chan In, s Stage[In, Out]) <-chan Out {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	out := make(chan Out, s.Buffer)
+	s.Metrics.WatchQueue(func() int { return len(in) })
+
+	if s.Seq != nil {
+		runOrdered(ctx, in, out, s, workers)
+		return out
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			work(ctx, in, out, s)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+func work[In, Out any](ctx context.Context, in <-chan In, out chan<- Out, s Stage[In, Out]) {
+	for v := range in {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		result, err := s.process(ctx, v)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case out <- result:
+			s.Metrics.IncOut()
+		case <-ctx.Done():
+		}
+	}
+}
+
+// process 执行 Handle，失败时按 Retry 重试，最终失败的数据发送到 Errors
+func (s Stage[In, Out]) process(ctx context.Context, v In) (Out, error) {
+	var result Out
+
+	clock := clockOr(s.Retry.Clock)
+
+	s.Metrics.IncIn()
+	start := clock.Now()
+	attempts, err := s.Retry.Do(ctx, func() error {
+		var err error
+		result, err = s.Handle(v)
+		return err
+	})
+	s.Metrics.Observe(clock.Now().Sub(start))
+
+	switch {
+	case err == ErrDrop:
+		s.Metrics.IncDropped()
+	case canceled(ctx, err):
+		// 重试被取消，该数据没有处理完成，不调用 Discard
+		return result, err
+	case err != nil:
+		s.Metrics.IncFailed()
+		if !sendFailure(ctx, s.Errors, clock.Now(), s.Name, v, attempts, err) {
+			return result, err
+		}
+	}
+	if err != nil && s.Discard != nil {
+		s.Discard(v, err)
+	}
+	return result, err
+}
+
+// Merge 将多个通道合并为一个通道（扇入），所有输入通道关闭后关闭输出通道
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for v := range in {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+				}
+			}
+		}(in)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Drain 读取并丢弃 in 中的全部数据，直到 in 关闭，返回丢弃的数量
+func Drain[T any](in <-chan T) int {
+	n := 0
+	for range in {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/16        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func source(n int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func TestThen(t *testing.T) {
+	double := Stage[int, int]{Name: "double", Workers: 4, Buffer: 8, Handle: func(v int) (int, error) { return v * 2, nil }}
+	format := Stage[int, string]{Name: "format", Workers: 2, Handle: func(v int) (string, error) { return strconv.Itoa(v), nil }}
+	parse := Stage[string, int]{Name: "parse", Workers: 3, Buffer: 1, Handle: strconv.Atoi}
+
+	flow := Then(Then(double.Flow(), format), parse)
+
+	var got []int
+	for v := range flow(context.Background(), source(100)) {
+		got = append(got, v)
+	}
+
+	if len(got) != 100 {
+		t.Fatalf("got %d values, want 100", len(got))
+	}
+
+	sort.Ints(got)
+	for i, v := range got {
+		if v != i*2 {
+			t.Fatalf("got[%d] = %d, want %d", i, v, i*2)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	inc := Stage[int, int]{Workers: 2, Handle: func(v int) (int, error) { return v + 1, nil }}
+
+	sum := 0
+	for v := range Chain(inc, inc, inc)(context.Background(), source(10)) {
+		sum += v
+	}
+
+	if want := 45 + 30; sum != want {
+		t.Fatalf("sum = %d, want %d", sum, want)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	block := Stage[int, int]{Workers: 2, Handle: func(v int) (int, error) { return v, nil }}
+	out := Run(ctx, source(1000), block)
+
+	<-out
+	cancel()
+
+	// 取消后输出通道必须被关闭，且上游 source 不会阻塞
+	Drain(out)
+}
+
+func TestRunDrop(t *testing.T) {
+	odd := Stage[int, int]{Workers: 3, Handle: func(v int) (int, error) {
+		if v%2 == 0 {
+			return 0, ErrDrop
+		}
+		return v, nil
+	}}
+
+	if n := Drain(Run(context.Background(), source(10), odd)); n != 5 {
+		t.Fatalf("got %d values, want 5", n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	n := Drain(Merge(context.Background(), source(3), source(4), source(5)))
+	if n != 12 {
+		t.Fatalf("merged %d values, want 12", n)
+	}
+}
+
+func TestStageCanceledNotDiscarded(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	close(in)
+
+	errs := make(chan Failure, 1)
+	discarded := 0
+	stage := Stage[int, int]{
+		Retry:   Retry{Attempts: 3, Backoff: time.Second, Clock: clock},
+		Errors:  errs,
+		Discard: func(int, error) { discarded++ },
+		Handle:  func(int) (int, error) { return 0, errors.New("temporary") },
+	}
+
+	out := Run(ctx, in, stage)
+	clock.BlockUntil(1)
+	cancel()
+	Drain(out)
+
+	if discarded != 0 || len(errs) != 0 {
+		t.Fatalf("discarded %d, dead letters %d after cancel", discarded, len(errs))
+	}
+}
@@ -0,0 +1,438 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/20        Feng Yifei
+ */
+
+// Package rules 根据 JSON 文件中声明的规则对记录进行匹配与改写，
+// 用于替代 PersonHandler.Handle 中写死的转换逻辑。
+//
+// 规则文件格式：
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "name": "haidian",
+//	      "priority": 10,
+//	      "when": [{"field": "address.district", "eq": "Haidian"}, {"field": "age", "min": 18, "max": 60}],
+//	      "then": [{"action": "set", "field": "address.district", "value": "Shijingshan"}]
+//	    }
+//	  ]
+//	}
+//
+// 字段路径以 "." 分隔，每一段匹配结构体字段的 json 标签或字段名（不区分大小写）。
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的动作
+const (
+	ActionSet  = "set"
+	ActionMap  = "map"
+	ActionDrop = "drop"
+)
+
+// Condition 对单个字段的匹配条件，设置的各项约束需要同时满足
+type Condition struct {
+	Field string        `json:"field"`
+	Eq    interface{}   `json:"eq,omitempty"`
+	Ne    interface{}   `json:"ne,omitempty"`
+	In    []interface{} `json:"in,omitempty"`
+	Min   *float64      `json:"min,omitempty"`
+	Max   *float64      `json:"max,omitempty"`
+
+	// JSON 中显式为 null 的 eq 或 ne，New 据此拒绝该条件
+	null string
+}
+
+// UnmarshalJSON 解析条件并记录显式为 null 的 eq 与 ne：null 解析后为 nil，与没有设置无法区分
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	type plain Condition
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+
+	c.null = ""
+	for _, key := range []string{"eq", "ne"} {
+		if v, ok := raw[key]; ok && string(v) == "null" {
+			c.null = key
+			break
+		}
+	}
+	return nil
+}
+
+// Action 规则命中后执行的动作
+type Action struct {
+	// set、map 或 drop
+	Type  string `json:"action"`
+	Field string `json:"field,omitempty"`
+	// set 使用的新值
+	Value interface{} `json:"value,omitempty"`
+	// map 使用的映射表，字段的当前值不在表中时不做修改
+	Mapping map[string]string `json:"mapping,omitempty"`
+}
+
+// Rule 一条规则，When 中的条件全部满足时依次执行 Then 中的动作
+type Rule struct {
+	Name string `json:"name"`
+	// 数值小的规则先执行，相同时按声明顺序执行
+	Priority int         `json:"priority"`
+	When     []Condition `json:"when"`
+	Then     []Action    `json:"then"`
+	// 命中后不再执行后续规则
+	Final bool `json:"final,omitempty"`
+}
+
+// Result 一条记录应用规则后的结果
+type Result struct {
+	// 记录被 drop 动作丢弃
+	Dropped bool
+	// 修改或丢弃了该记录的规则名称，按执行顺序排列
+	Applied []string
+}
+
+// Engine 规则引擎
+type Engine struct {
+	rules []Rule
+}
+
+// New 校验 rules 并按优先级排序后创建规则引擎
+func New(rules ...Rule) (*Engine, error) {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+
+	for i, r := range sorted {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rules: rule #%d has no name", i)
+		}
+		for _, c := range r.When {
+			if c.Field == "" {
+				return nil, fmt.Errorf("rules: rule %s: condition without field", r.Name)
+			}
+			if c.null != "" {
+				return nil, fmt.Errorf("rules: rule %s: condition on %s has null %q", r.Name, c.Field, c.null)
+			}
+			if c.Eq == nil && c.Ne == nil && c.In == nil && c.Min == nil && c.Max == nil {
+				return nil, fmt.Errorf("rules: rule %s: condition on %s has no eq, ne, in, min or max", r.Name, c.Field)
+			}
+			for _, v := range c.In {
+				if v == nil {
+					return nil, fmt.Errorf("rules: rule %s: condition on %s has null in \"in\"", r.Name, c.Field)
+				}
+			}
+		}
+		if len(r.Then) == 0 {
+			return nil, fmt.Errorf("rules: rule %s has no action", r.Name)
+		}
+		for _, a := range r.Then {
+			switch a.Type {
+			case ActionSet, ActionMap:
+				if a.Field == "" {
+					return nil, fmt.Errorf("rules: rule %s: %s action without field", r.Name, a.Type)
+				}
+				if a.Type == ActionSet && a.Value == nil {
+					return nil, fmt.Errorf("rules: rule %s: set action on %s without value", r.Name, a.Field)
+				}
+			case ActionDrop:
+			default:
+				return nil, fmt.Errorf("rules: rule %s: unknown action %q", r.Name, a.Type)
+			}
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	return &Engine{rules: sorted}, nil
+}
+
+// Parse 从 JSON 数据创建规则引擎
+func Parse(data []byte) (*Engine, error) {
+	var file struct {
+		Rules []Rule `json:"rules"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("rules: %v", err)
+	}
+	return New(file.Rules...)
+}
+
+// Load 从 JSON 文件创建规则引擎
+func Load(path string) (*Engine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Rules 返回按执行顺序排列的规则
+func (e *Engine) Rules() []Rule {
+	rules := make([]Rule, len(e.rules))
+	copy(rules, e.rules)
+	return rules
+}
+
+// Apply 按优先级对 record 应用规则，record 必须是指向结构体的指针。
+// 记录被丢弃后不再执行后续规则。
+func (e *Engine) Apply(record interface{}) (Result, error) {
+	var result Result
+
+	v := reflect.ValueOf(record)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return result, fmt.Errorf("rules: record must be a pointer to struct, got %T", record)
+	}
+	v = v.Elem()
+
+	for _, r := range e.rules {
+		matched, err := r.match(v)
+		if err != nil {
+			return result, err
+		}
+		if !matched {
+			continue
+		}
+
+		changed, dropped, err := r.apply(v)
+		if err != nil {
+			return result, err
+		}
+		if changed || dropped {
+			result.Applied = append(result.Applied, r.Name)
+		}
+		if dropped {
+			result.Dropped = true
+			return result, nil
+		}
+		if r.Final {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Rule) match(record reflect.Value) (bool, error) {
+	for _, c := range r.When {
+		field, err := lookup(record, c.Field)
+		if err != nil {
+			return false, fmt.Errorf("rules: rule %s: %v", r.Name, err)
+		}
+
+		ok, err := c.match(field)
+		if err != nil {
+			return false, fmt.Errorf("rules: rule %s: field %s: %v", r.Name, c.Field, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *Rule) apply(record reflect.Value) (changed, dropped bool, err error) {
+	for _, a := range r.Then {
+		if a.Type == ActionDrop {
+			return changed, true, nil
+		}
+
+		field, err := lookup(record, a.Field)
+		if err != nil {
+			return changed, false, fmt.Errorf("rules: rule %s: %v", r.Name, err)
+		}
+
+		value := a.Value
+		if a.Type == ActionMap {
+			mapped, ok := a.Mapping[text(field)]
+			if !ok {
+				continue
+			}
+			value = mapped
+		}
+
+		old := field.Interface()
+		if err = assign(field, value); err != nil {
+			return changed, false, fmt.Errorf("rules: rule %s: field %s: %v", r.Name, a.Field, err)
+		}
+		if !reflect.DeepEqual(old, field.Interface()) {
+			changed = true
+		}
+	}
+	return changed, false, nil
+}
+
+func (c *Condition) match(field reflect.Value) (bool, error) {
+	current := text(field)
+
+	if c.Eq != nil && current != text(reflect.ValueOf(c.Eq)) {
+		return false, nil
+	}
+	if c.Ne != nil && current == text(reflect.ValueOf(c.Ne)) {
+		return false, nil
+	}
+	if c.In != nil {
+		found := false
+		for _, v := range c.In {
+			if current == text(reflect.ValueOf(v)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if c.Min != nil || c.Max != nil {
+		n, err := number(field)
+		if err != nil {
+			return false, err
+		}
+		if c.Min != nil && n < *c.Min {
+			return false, nil
+		}
+		if c.Max != nil && n > *c.Max {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// lookup 按路径查找结构体字段
+func lookup(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, fmt.Errorf("field %s: nil pointer", path)
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return v, fmt.Errorf("field %s: %s is not a struct", path, v.Type())
+		}
+
+		field, ok := structField(v, name)
+		if !ok {
+			return v, fmt.Errorf("field %s: no field %s in %s", path, name, v.Type())
+		}
+		v = field
+	}
+	return v, nil
+}
+
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name || strings.EqualFold(f.Name, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// text 返回值的文本形式，JSON 中的数字 32 与整型字段 32 的文本形式相同；nil 的文本形式为空
+func text(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return ""
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func number(v reflect.Value) (float64, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	return 0, errors.New("not a number")
+}
+
+// assign 将 JSON 中的值转换为字段类型后赋值
+func assign(field reflect.Value, value interface{}) error {
+	s := text(reflect.ValueOf(value))
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || field.OverflowInt(n) {
+			return fmt.Errorf("invalid value %q for %s", s, field.Type())
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || field.OverflowUint(n) {
+			return fmt.Errorf("invalid value %q for %s", s, field.Type())
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s", s, field.Type())
+		}
+		field.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s", s, field.Type())
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/20        Feng Yifei
+ */
+
+package rules
+
+import (
+	"reflect"
+	"testing"
+)
+
+type addr struct {
+	City     string `json:"city"`
+	District string `json:"district"`
+}
+
+type person struct {
+	Name    string `json:"name"`
+	Age     uint8  `json:"age"`
+	Address addr   `json:"address"`
+}
+
+func TestLoad(t *testing.T) {
+	engine, err := Load("testdata/rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		in      person
+		out     person
+		dropped bool
+		applied []string
+	}{
+		{
+			in:      person{"P0", 32, addr{"Beijing", "Haidian"}},
+			out:     person{"P0", 32, addr{"Beijing", "Shijingshan"}},
+			applied: []string{"haidian"},
+		},
+		{
+			in:  person{"P1", 32, addr{"Beijing", "Dongcheng"}},
+			out: person{"P1", 32, addr{"Beijing", "Dongcheng"}},
+		},
+		{
+			in:      person{"P2", 12, addr{"Beijing", "Haidian"}},
+			out:     person{"P2", 12, addr{"Beijing", "Haidian"}},
+			dropped: true,
+			applied: []string{"drop-minors"},
+		},
+		{
+			in:      person{"P3", 70, addr{"Beijing", "Haidian"}},
+			out:     person{"P3", 70, addr{"Tianjin", "Haidian"}},
+			applied: []string{"retired"},
+		},
+	}
+
+	for _, c := range cases {
+		p := c.in
+		result, err := engine.Apply(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p != c.out {
+			t.Errorf("%s: got %+v, want %+v", c.in.Name, p, c.out)
+		}
+		if result.Dropped != c.dropped || !reflect.DeepEqual(result.Applied, c.applied) {
+			t.Errorf("%s: got %+v, want dropped=%v applied=%v", c.in.Name, result, c.dropped, c.applied)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	invalid := []string{
+		`{"rules": [{"name": "a", "then": [{"action": "rename", "field": "name"}]}]}`,
+		`{"rules": [{"name": "a"}]}`,
+		`{"rules": [{"then": [{"action": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "then": [{"action": "set"}]}]}`,
+		`{"rules": [{"name": "a", "then": [{"action": "set", "field": "name"}]}]}`,
+		`{"rules": [{"name": "a", "then": [{"action": "set", "field": "name", "value": null}]}]}`,
+		`{"rules": [{"name": "a", "when": [{"field": "name", "in": ["x", null]}], "then": [{"action": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "when": [{"field": "name", "eq": null}], "then": [{"action": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "when": [{"field": "name", "ne": null}], "then": [{"action": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "when": [{"field": "name"}], "then": [{"action": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "when": [{"field": "name", "eq": 1, "ne": null}], "then": [{"action": "drop"}]}]}`,
+		`{"rules": `,
+	}
+
+	for _, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s) succeeded", data)
+		}
+	}
+
+	// 直接构造的条件同样需要至少一项约束
+	if _, err := New(Rule{Name: "a", When: []Condition{{Field: "name"}}, Then: []Action{{Type: ActionDrop}}}); err == nil {
+		t.Error("New accepted a condition without constraint")
+	}
+
+	if s := text(reflect.ValueOf(nil)); s != "" {
+		t.Errorf("text(nil) = %q, want empty", s)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	engine, err := New(
+		Rule{Name: "age", Then: []Action{{Type: ActionSet, Field: "age", Value: 300}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.Apply(&person{}); err == nil {
+		t.Error("Apply overflowed uint8 without error")
+	}
+	if _, err = engine.Apply(person{}); err == nil {
+		t.Error("Apply accepted a non-pointer record")
+	}
+
+	engine, _ = New(Rule{Name: "missing", Then: []Action{{Type: ActionSet, Field: "address.zip", Value: "100000"}}})
+	if _, err = engine.Apply(&person{}); err == nil {
+		t.Error("Apply accepted an unknown field")
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/18        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SinkPolicy 接收端的等待与失败处理策略，Idle 与 Deadline 都为 0 时一直等待到输入通道关闭
+type SinkPolicy struct {
+	// 连续 Idle 时长内没有收到任何数据则停止接收
+	Idle time.Duration
+	// 从开始接收算起，最多接收 Deadline 时长
+	Deadline time.Duration
+	// 保存失败时的重试策略
+	Retry Retry
+	// 重试后仍然保存失败的数据发送到 Errors，为 nil 时直接丢弃
+	Errors chan<- Failure
+	// 发送到 Errors 时使用的阶段名称，为空时使用 "save"
+	Name string
+	// 每条记录保存成功或最终失败并写入 Errors 后调用，err 为最后一次保存的错误，可用于提交检查点；
+	// 因 ctx 结束而中断的记录不会调用
+	Done func(record interface{}, err error)
+	// 接收端的统计数据，为 nil 时不统计
+	Metrics *StageMetrics
+	// 超时与计时使用的时钟，为 nil 时使用 SystemClock；Retry 未设置时钟时同样使用该时钟
+	Clock Clock
+}
+
+// WaitForever 一直等待到输入通道关闭
+var WaitForever = SinkPolicy{}
+
+// IdleTimeout 返回空闲超时策略
+func IdleTimeout(idle time.Duration) SinkPolicy {
+	return SinkPolicy{Idle: idle}
+}
+
+// Deadline 返回总时长策略
+func Deadline(deadline time.Duration) SinkPolicy {
+	return SinkPolicy{Deadline: deadline}
+}
+
+// StopReason 接收端停止的原因
+type StopReason int
+
+// 接收端停止的原因
+const (
+	StopClosed StopReason = iota
+	StopIdle
+	StopDeadline
+	StopCanceled
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopClosed:
+		return "closed"
+	case StopIdle:
+		return "idle timeout"
+	case StopDeadline:
+		return "deadline exceeded"
+	case StopCanceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// SinkReport 接收端的统计结果
+type SinkReport struct {
+	// 保存成功的记录数
+	Saved int
+	// 重试后仍然保存失败被丢弃的记录数
+	Dropped int
+	// 重试的总次数
+	Retried int
+	// 停止时仍缓冲在输入通道中、未被读取的记录数，不包括阻塞在发送上的记录
+	Pending int
+	// 停止原因
+	Reason StopReason
+	// 接收耗时
+	Elapsed time.Duration
+}
+
+func (r SinkReport) String() string {
+	return fmt.Sprintf("saved=%d dropped=%d retried=%d pending=%d reason=%s elapsed=%v",
+		r.Saved, r.Dropped, r.Retried, r.Pending, r.Reason, r.Elapsed)
+}
+
+// Save 从 in 读取记录并调用 save 保存，直到 in 关闭、ctx 结束或触发 policy 中的超时。
+// 整个过程只使用两个 timer，不会为每条记录启动 goroutine，也不会为每条记录重置 timer：
+// 空闲 timer 到期时如果期间保存过记录，则按最后一次保存的时间重新计时。
+func Save[T any](ctx context.Context, in <-chan T, save func(T) error, policy SinkPolicy) SinkReport {
+	name := policy.Name
+	if name == "" {
+		name = "save"
+	}
+
+	clock := clockOr(policy.Clock)
+	if policy.Retry.Clock == nil {
+		policy.Retry.Clock = clock
+	}
+
+	var (
+		report SinkReport
+		start  = clock.Now()
+		last   = start // 最后一次保存完成的时间
+		idle   <-chan time.Time
+		dead   <-chan time.Time
+	)
+
+	if policy.Deadline > 0 {
+		deadline := clock.NewTimer(policy.Deadline)
+		defer deadline.Stop()
+		dead = deadline.C()
+	}
+
+	var idleTimer Timer
+	if policy.Idle > 0 {
+		idleTimer = clock.NewTimer(policy.Idle)
+		defer idleTimer.Stop()
+		idle = idleTimer.C()
+	}
+
+	policy.Metrics.WatchQueue(func() int { return len(in) })
+
+	stop := func(reason StopReason) SinkReport {
+		report.Reason = reason
+		report.Pending = len(in)
+		report.Elapsed = clock.Now().Sub(start)
+		return report
+	}
+
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return stop(StopClosed)
+			}
+
+			policy.Metrics.IncIn()
+			begin := clock.Now()
+			attempts, err := policy.Retry.Do(ctx, func() error { return save(v) })
+			last = clock.Now()
+			policy.Metrics.Observe(last.Sub(begin))
+
+			// 重试被取消或失败数据没能写入死信时，该记录既未保存也未丢弃，不调用 Done，恢复后重新处理
+			report.Retried += attempts - 1
+			if canceled(ctx, err) {
+				return stop(StopCanceled)
+			}
+			if err == nil {
+				report.Saved++
+				policy.Metrics.IncOut()
+			} else {
+				if !sendFailure(ctx, policy.Errors, last, name, v, attempts, err) {
+					return stop(StopCanceled)
+				}
+				report.Dropped++
+				policy.Metrics.IncFailed()
+			}
+			if policy.Done != nil {
+				policy.Done(v, err)
+			}
+		case now := <-idle:
+			if wait := policy.Idle - now.Sub(last); wait > 0 {
+				idleTimer.Reset(wait)
+				continue
+			}
+			return stop(StopIdle)
+		case <-dead:
+			return stop(StopDeadline)
+		case <-ctx.Done():
+			return stop(StopCanceled)
+		}
+	}
+}
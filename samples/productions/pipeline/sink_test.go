@@ -0,0 +1,289 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/18        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSinkClosed(t *testing.T) {
+	in := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		in <- i
+	}
+	close(in)
+
+	report := Save(context.Background(), in, func(v int) error {
+		if v%2 == 0 {
+			return nil
+		}
+		return errors.New("odd")
+	}, WaitForever)
+	if report.Saved != 5 || report.Dropped != 5 || report.Pending != 0 || report.Reason != StopClosed {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestSinkIdle(t *testing.T) {
+	in := make(chan int, 10)
+	in <- 1
+
+	report := Save(context.Background(), in, func(int) error { return nil }, IdleTimeout(10*time.Millisecond))
+	if report.Saved != 1 || report.Reason != StopIdle {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestSinkDeadline(t *testing.T) {
+	in := make(chan int, 1)
+	in <- 1
+
+	report := Save(context.Background(), in, func(v int) error {
+		in <- v
+		time.Sleep(time.Millisecond)
+		return nil
+	}, SinkPolicy{Deadline: 20 * time.Millisecond})
+
+	if report.Reason != StopDeadline {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestSinkCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+
+	report := Save(ctx, in, func(int) error {
+		cancel()
+		return nil
+	}, WaitForever)
+
+	if report.Reason != StopCanceled || report.Saved+report.Pending != 3 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+// startSave 在后台使用 clock 运行 Save，每条记录处理完成后向 saved 发送一次
+func startSave(ctx context.Context, in <-chan int, save func(int) error, policy SinkPolicy, clock *FakeClock) (<-chan SinkReport, <-chan struct{}) {
+	saved := make(chan struct{})
+	policy.Clock = clock
+	policy.Done = func(interface{}, error) { saved <- struct{}{} }
+
+	result := make(chan SinkReport, 1)
+	go func() {
+		result <- Save(ctx, in, save, policy)
+	}()
+	return result, saved
+}
+
+func saveNothing(int) error { return nil }
+
+func TestSinkIdleFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	in := make(chan int)
+	result, saved := startSave(context.Background(), in, saveNothing, IdleTimeout(time.Second), clock)
+
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	in <- 1
+	<-saved
+
+	// 空闲 timer 到期时距最后一次保存只有 500ms，重新计时
+	clock.Advance(500 * time.Millisecond)
+	clock.BlockUntil(1)
+	select {
+	case report := <-result:
+		t.Fatalf("stopped early: %s", report)
+	default:
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	report := <-result
+	if report.Reason != StopIdle || report.Saved != 1 || report.Elapsed != 1500*time.Millisecond {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	if clock.Timers() != 0 {
+		t.Fatalf("%d timers left", clock.Timers())
+	}
+}
+
+func TestSinkDeadlineFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	in := make(chan int)
+	result, saved := startSave(context.Background(), in, saveNothing, SinkPolicy{Idle: time.Second, Deadline: 2 * time.Second}, clock)
+
+	// 数据持续到达时空闲超时不会触发，直到总时长用完
+	clock.BlockUntil(2)
+	for i := 0; i < 4; i++ {
+		in <- i
+		<-saved
+		clock.Advance(500 * time.Millisecond)
+	}
+
+	report := <-result
+	if report.Reason != StopDeadline || report.Saved != 4 || report.Elapsed != 2*time.Second {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	if clock.Timers() != 0 {
+		t.Fatalf("%d timers left", clock.Timers())
+	}
+}
+
+func TestSinkEarlyCloseFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	in := make(chan int, 2)
+	in <- 1
+	in <- 2
+	close(in)
+
+	result, saved := startSave(context.Background(), in, saveNothing, SinkPolicy{Idle: time.Second, Deadline: time.Minute}, clock)
+	<-saved
+	<-saved
+
+	report := <-result
+	if report.Reason != StopClosed || report.Saved != 2 || report.Elapsed != 0 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	if clock.Timers() != 0 {
+		t.Fatalf("%d timers left", clock.Timers())
+	}
+}
+
+func TestSinkCanceledFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	result, _ := startSave(ctx, in, saveNothing, IdleTimeout(time.Second), clock)
+
+	clock.BlockUntil(1)
+	clock.Advance(999 * time.Millisecond)
+	cancel()
+
+	report := <-result
+	if report.Reason != StopCanceled || report.Elapsed != 999*time.Millisecond {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestSinkRetryFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	in := make(chan int, 1)
+	in <- 1
+	close(in)
+
+	calls := 0
+	save := func(int) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	}
+
+	// Retry 没有设置时钟，使用 policy 的时钟等待
+	result, saved := startSave(context.Background(), in, save, SinkPolicy{Retry: Retry{Attempts: 3, Backoff: time.Second}}, clock)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Second)
+	<-saved
+
+	report := <-result
+	if report.Saved != 1 || report.Retried != 2 || report.Elapsed != 3*time.Second {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestSinkCanceledRetryNotCommitted(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+
+	errs := make(chan Failure, 1)
+	done := 0
+	policy := SinkPolicy{
+		Retry:  Retry{Attempts: 3, Backoff: time.Second},
+		Errors: errs,
+		Clock:  clock,
+		Done:   func(interface{}, error) { done++ },
+	}
+
+	result := make(chan SinkReport, 1)
+	go func() {
+		result <- Save(ctx, in, func(int) error { return errors.New("temporary") }, policy)
+	}()
+
+	// 在第一次重试的等待期间取消
+	clock.BlockUntil(1)
+	cancel()
+
+	report := <-result
+	if report.Reason != StopCanceled || report.Dropped != 0 || done != 0 || len(errs) != 0 {
+		t.Fatalf("report %s, done %d, dead letters %d", report, done, len(errs))
+	}
+}
+
+func TestSinkCanceledFailureNotCommitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+
+	// 没有人接收的死信通道，失败数据无法写入
+	errs := make(chan Failure)
+	done := 0
+	called := make(chan struct{})
+	policy := SinkPolicy{
+		Errors: errs,
+		Done:   func(interface{}, error) { done++ },
+	}
+
+	result := make(chan SinkReport, 1)
+	go func() {
+		result <- Save(ctx, in, func(int) error {
+			close(called)
+			return errors.New("disk full")
+		}, policy)
+	}()
+
+	<-called
+	cancel()
+
+	report := <-result
+	if report.Reason != StopCanceled || report.Dropped != 0 || done != 0 {
+		t.Fatalf("report %s, done %d", report, done)
+	}
+}
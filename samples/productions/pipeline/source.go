@@ -0,0 +1,151 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/19        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Source 记录来源，所有记录读完后 Read 返回 io.EOF
+type Source[T any] interface {
+	Read() (T, error)
+	Close() error
+}
+
+// Sink 记录去向，Flush 可以与 Write 并发调用
+type Sink[T any] interface {
+	Write(T) error
+	// 将已写入的记录从缓冲区刷新到底层存储
+	Flush() error
+	Close() error
+}
+
+// Feed 从 src 读取全部记录并发送到 out，读完或出错后关闭 out。
+// 读到 io.EOF 时返回 nil，ctx 结束时返回 ctx.Err()。
+func Feed[T any](ctx context.Context, src Source[T], out chan<- T) error {
+	defer close(out)
+
+	for {
+		v, err := src.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case out <- v:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// MemorySource 基于内存切片的记录来源，主要用于测试
+type MemorySource[T any] struct {
+	mu      sync.Mutex
+	records []T
+	next    int
+}
+
+// NewMemorySource 创建从 records 依次读取的来源
+func NewMemorySource[T any](records []T) *MemorySource[T] {
+	return &MemorySource[T]{records: records}
+}
+
+// Read 读取下一条记录
+func (s *MemorySource[T]) Read() (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v T
+	if s.next >= len(s.records) {
+		return v, io.EOF
+	}
+
+	v = s.records[s.next]
+	s.next++
+	return v, nil
+}
+
+// Close 无需释放任何资源
+func (s *MemorySource[T]) Close() error {
+	return nil
+}
+
+// MemorySink 将记录保存在内存中的去向，主要用于测试
+type MemorySink[T any] struct {
+	mu      sync.Mutex
+	records []T
+}
+
+// NewMemorySink 创建空的内存去向
+func NewMemorySink[T any]() *MemorySink[T] {
+	return &MemorySink[T]{}
+}
+
+// Write 保存一条记录
+func (s *MemorySink[T]) Write(v T) error {
+	s.mu.Lock()
+	s.records = append(s.records, v)
+	s.mu.Unlock()
+	return nil
+}
+
+// Flush 无需刷新
+func (s *MemorySink[T]) Flush() error {
+	return nil
+}
+
+// Close 无需释放任何资源
+func (s *MemorySink[T]) Close() error {
+	return nil
+}
+
+// Records 返回已保存记录的副本
+func (s *MemorySink[T]) Records() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]T, len(s.records))
+	copy(records, s.records)
+	return records
+}
+
+// closeIfCloser 在 v 实现了 io.Closer 时关闭它
+func closeIfCloser(v interface{}) error {
+	if c, ok := v.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
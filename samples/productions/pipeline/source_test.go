@@ -0,0 +1,196 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/19        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type record struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+var records = []record{{"P0", 32}, {"P1", 33}, {"P2", 34}}
+
+var recordCSV = CSVCodec[record]{
+	Header: []string{"name", "age"},
+	Encode: func(r record) ([]string, error) {
+		return []string{r.Name, strconv.Itoa(r.Age)}, nil
+	},
+	Decode: func(row []string) (record, error) {
+		age, err := strconv.Atoi(row[1])
+		return record{row[0], age}, err
+	},
+}
+
+// copyAll 将 src 中的全部记录写入 sink，并关闭两者
+func copyAll[T any](t *testing.T, src Source[T], sink Sink[T]) {
+	ch := make(chan T)
+	go func() {
+		if err := Feed(context.Background(), src, ch); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	report := Save(context.Background(), ch, sink.Write, WaitForever)
+	if report.Dropped != 0 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll[T any](t *testing.T, src Source[T]) []T {
+	sink := NewMemorySink[T]()
+	copyAll[T](t, src, sink)
+	return sink.Records()
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	copyAll[record](t, NewMemorySource(records), NewCSVSink(&buf, recordCSV))
+
+	if want := "name,age\nP0,32\nP1,33\nP2,34\n"; buf.String() != want {
+		t.Fatalf("csv = %q, want %q", buf.String(), want)
+	}
+
+	got := readAll[record](t, NewCSVSource(&buf, recordCSV))
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("got %v, want %v", got, records)
+	}
+}
+
+func TestCSVDecodeError(t *testing.T) {
+	src := NewCSVSource(bytes.NewBufferString("name,age\nP0,x\n"), recordCSV)
+	if _, err := src.Read(); err == nil || err == io.EOF {
+		t.Fatalf("Read = %v, want decode error", err)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	copyAll[record](t, NewMemorySource(records), NewJSONLinesSink[record](&buf))
+
+	got := readAll[record](t, NewJSONLinesSource[record](&buf))
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("got %v, want %v", got, records)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewDirSink(dir, func(r record) string { return r.Name })
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyAll[record](t, NewMemorySource(records), sink)
+
+	src, err := NewDirSource[record](dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll[record](t, src)
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("got %v, want %v", got, records)
+	}
+}
+
+func TestDirSinkName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewDirSink(filepath.Join(dir, "out"), func(r record) string { return r.Name })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../x", "a/b", `a\b`, "..", ".", ""} {
+		if err := sink.Write(record{Name: name}); err == nil {
+			t.Errorf("Write(%q) succeeded", name)
+		}
+	}
+
+	// 目录之外没有写入任何文件
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("files %v, want only the sink directory", files)
+	}
+}
+
+func TestDirSinkCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewDirSink(dir, func(r record) string { return r.Name })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(record{Name: "alice", Age: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(record{Name: "alice", Age: 2}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("err = %v, want %v", err, os.ErrExist)
+	}
+
+	// 第一条记录没有被覆盖
+	src, err := NewDirSource[record](dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll[record](t, src); !reflect.DeepEqual(got, []record{{Name: "alice", Age: 1}}) {
+		t.Fatalf("got %v", got)
+	}
+}
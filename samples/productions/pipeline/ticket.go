@@ -0,0 +1,229 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/17        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Tickets 票池，用于限制同时运行的 goroutine 数量。
+// 每个 goroutine 启动前必须先获取一张票，完成后归还；票池容量可以在运行中调整。
+type Tickets struct {
+	mu      sync.Mutex
+	total   int
+	inUse   int
+	waiters list.List     // 等待中的获取者，元素类型为 chan struct{}
+	idle    chan struct{} // 所有票都已归还时关闭
+	clock   Clock
+}
+
+// NewTickets 创建容量为 total 的票池，total 小于 1 时按 1 处理
+func NewTickets(total int) *Tickets {
+	if total < 1 {
+		total = 1
+	}
+
+	idle := make(chan struct{})
+	close(idle)
+
+	return &Tickets{
+		total: total,
+		idle:  idle,
+		clock: SystemClock,
+	}
+}
+
+// SetClock 设置 AcquireTimeout 计时使用的时钟
+func (t *Tickets) SetClock(clock Clock) {
+	t.mu.Lock()
+	t.clock = clockOr(clock)
+	t.mu.Unlock()
+}
+
+// Acquire 获取一张票，没有可用的票时阻塞，直到有票归还或 ctx 结束；ctx 已经结束时不获取
+func (t *Tickets) Acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !t.acquire(ctx.Done(), nil) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// AcquireTimeout 在 timeout 内获取一张票，超时返回 false
+func (t *Tickets) AcquireTimeout(timeout time.Duration) bool {
+	t.mu.Lock()
+	clock := t.clock
+	t.mu.Unlock()
+
+	timer := clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	return t.acquire(nil, timer.C())
+}
+
+// acquire 获取一张票，done 关闭或 expired 触发时放弃等待并返回 false
+func (t *Tickets) acquire(done <-chan struct{}, expired <-chan time.Time) bool {
+	t.mu.Lock()
+	if t.inUse < t.total && t.waiters.Len() == 0 {
+		t.take()
+		t.mu.Unlock()
+		return true
+	}
+
+	ready := make(chan struct{})
+	elem := t.waiters.PushBack(ready)
+	t.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-done:
+	case <-expired:
+	}
+
+	t.mu.Lock()
+	select {
+	case <-ready:
+		// 放弃的同时已经拿到了票，归还后再返回
+		t.mu.Unlock()
+		t.Release()
+	default:
+		t.waiters.Remove(elem)
+		// 队首的等待者离开后，后面的等待者可能已经可以获取
+		t.notify()
+		t.mu.Unlock()
+	}
+	return false
+}
+
+// TryAcquire 尝试获取一张票，不阻塞
+func (t *Tickets) TryAcquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inUse < t.total && t.waiters.Len() == 0 {
+		t.take()
+		return true
+	}
+	return false
+}
+
+// Release 归还一张票
+func (t *Tickets) Release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inUse == 0 {
+		panic("pipeline: release of unacquired ticket")
+	}
+
+	t.inUse--
+	if t.inUse == 0 {
+		close(t.idle)
+	}
+	t.notify()
+}
+
+// Wait 阻塞直到所有的票都已归还或 ctx 结束
+func (t *Tickets) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resize 调整票池容量，total 小于 1 时按 1 处理。
+// 缩小容量时不会收回已发出的票，新的获取者需要等到使用中的票数低于新容量。
+func (t *Tickets) Resize(total int) {
+	if total < 1 {
+		total = 1
+	}
+
+	t.mu.Lock()
+	t.total = total
+	t.notify()
+	t.mu.Unlock()
+}
+
+// Total 返回票池容量
+func (t *Tickets) Total() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.total
+}
+
+// InUse 返回已被取走、尚未归还的票数
+func (t *Tickets) InUse() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.inUse
+}
+
+// Waiting 返回正在等待获取票的 goroutine 数量
+func (t *Tickets) Waiting() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.waiters.Len()
+}
+
+// take 取走一张票，调用方需持有 t.mu
+func (t *Tickets) take() {
+	if t.inUse == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.inUse++
+}
+
+// notify 按等待顺序把空闲的票交给等待者，调用方需持有 t.mu
+func (t *Tickets) notify() {
+	for t.inUse < t.total {
+		front := t.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		t.take()
+		close(t.waiters.Remove(front).(chan struct{}))
+	}
+}
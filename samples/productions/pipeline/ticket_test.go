@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/07/17        Feng Yifei
+ */
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTicketsLimit(t *testing.T) {
+	tickets := NewTickets(3)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running int
+		peak    int
+	)
+
+	for i := 0; i < 50; i++ {
+		if err := tickets.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer tickets.Release()
+
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+
+	if err := tickets.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if peak > 3 {
+		t.Fatalf("peak = %d, want <= 3", peak)
+	}
+	if n := tickets.InUse(); n != 0 {
+		t.Fatalf("InUse = %d after Wait, want 0", n)
+	}
+}
+
+func TestTicketsZeroTotal(t *testing.T) {
+	tickets := NewTickets(0)
+
+	if !tickets.TryAcquire() {
+		t.Fatal("TryAcquire failed on empty pool of size 0")
+	}
+	if tickets.TryAcquire() {
+		t.Fatal("TryAcquire succeeded beyond capacity")
+	}
+}
+
+func TestTicketsCancel(t *testing.T) {
+	tickets := NewTickets(1)
+	tickets.TryAcquire()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tickets.Acquire(ctx) }()
+
+	for tickets.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Acquire = %v, want %v", err, context.Canceled)
+	}
+	if n := tickets.Waiting(); n != 0 {
+		t.Fatalf("Waiting = %d after cancel, want 0", n)
+	}
+	if tickets.AcquireTimeout(time.Millisecond) {
+		t.Fatal("AcquireTimeout succeeded on exhausted pool")
+	}
+}
+
+func TestTicketsAcquireCanceled(t *testing.T) {
+	tickets := NewTickets(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 有空闲的票时同样不获取
+	if err := tickets.Acquire(ctx); err != context.Canceled {
+		t.Fatalf("Acquire = %v, want %v", err, context.Canceled)
+	}
+	if n := tickets.InUse(); n != 0 {
+		t.Fatalf("InUse = %d after canceled Acquire, want 0", n)
+	}
+}
+
+func TestTicketsResize(t *testing.T) {
+	tickets := NewTickets(1)
+	tickets.TryAcquire()
+
+	done := make(chan error)
+	go func() { done <- tickets.Acquire(context.Background()) }()
+
+	for tickets.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	tickets.Resize(2)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := tickets.InUse(); n != 2 {
+		t.Fatalf("InUse = %d, want 2", n)
+	}
+
+	tickets.Release()
+	tickets.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tickets.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTicketsAcquireTimeoutFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	tickets := NewTickets(1)
+	tickets.SetClock(clock)
+	tickets.TryAcquire()
+
+	result := make(chan bool, 1)
+	go func() {
+		result <- tickets.AcquireTimeout(time.Second)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-result:
+		t.Fatal("AcquireTimeout returned before the timeout")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	if <-result {
+		t.Fatal("AcquireTimeout succeeded without a free ticket")
+	}
+	if tickets.Waiting() != 0 {
+		t.Fatalf("%d waiters left", tickets.Waiting())
+	}
+
+	go func() {
+		result <- tickets.AcquireTimeout(time.Second)
+	}()
+
+	clock.BlockUntil(1)
+	tickets.Release()
+	if !<-result {
+		t.Fatal("AcquireTimeout failed after a release")
+	}
+	clock.BlockUntil(0)
+}
@@ -0,0 +1,296 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/10        Feng Yifei
+ */
+
+// hash-bench saves and compares the go test -bench output of hash-compare:
+//
+//	go test -bench Hash -count 10 ./hash-compare | hash-bench save > old.json
+//	go test -bench Hash -count 10 ./hash-compare | hash-bench save > new.json
+//	hash-bench compare old.json new.json
+//
+// compare runs a Mann-Whitney U test per benchmark and shows the delta as "~"
+// when the difference is not significant.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Run is the output of one go test -bench invocation.
+type Run struct {
+	// Config holds the goos, goarch, pkg, cpu and other configuration lines.
+	Config map[string]string `json:"config"`
+	// Benchmarks are in order of first appearance.
+	Benchmarks []*Benchmark `json:"benchmarks"`
+}
+
+// Benchmark holds the results of repeated runs (-count) of one benchmark.
+type Benchmark struct {
+	// Name has the Benchmark prefix and -GOMAXPROCS suffix removed.
+	Name    string    `json:"name"`
+	Samples []*Sample `json:"samples"`
+}
+
+// Sample is one line of benchmark output.
+type Sample struct {
+	Iterations int64 `json:"iterations"`
+	// Metrics maps units such as "ns/op", "MB/s" and "B/op" to values.
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+var (
+	configLine = regexp.MustCompile(`^([a-z]+): (.*)$`)
+	procs      = regexp.MustCompile(`-\d+$`)
+)
+
+// Parse parses go test -bench output, ignoring lines it does not recognize.
+func Parse(r io.Reader) (*Run, error) {
+	run := &Run{Config: make(map[string]string)}
+	index := make(map[string]*Benchmark)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := configLine.FindStringSubmatch(line); m != nil {
+			run.Config[m[1]] = m[2]
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		iterations, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		sample := &Sample{Iterations: iterations, Metrics: make(map[string]float64)}
+		for i := 2; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q in %q", fields[i], line)
+			}
+			sample.Metrics[fields[i+1]] = v
+		}
+
+		name := procs.ReplaceAllString(strings.TrimPrefix(fields[0], "Benchmark"), "")
+		b, ok := index[name]
+		if !ok {
+			b = &Benchmark{Name: name}
+			index[name] = b
+			run.Benchmarks = append(run.Benchmarks, b)
+		}
+		b.Samples = append(b.Samples, sample)
+	}
+	return run, scanner.Err()
+}
+
+// values returns the unit values of all runs.
+func (b *Benchmark) values(unit string) []float64 {
+	var vs []float64
+	for _, s := range b.Samples {
+		if v, ok := s.Metrics[unit]; ok {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// Row is one line of a comparison.
+type Row struct {
+	Name     string
+	Old, New Summary
+	// Delta is the relative change, meaningless when Significant is false.
+	Delta       float64
+	P           float64
+	Significant bool
+}
+
+// Compare compares the unit metric of the benchmarks present in both runs at
+// significance level alpha.
+func Compare(old, new *Run, unit string, alpha float64) []Row {
+	index := make(map[string]*Benchmark)
+	for _, b := range new.Benchmarks {
+		index[b.Name] = b
+	}
+
+	var rows []Row
+	for _, ob := range old.Benchmarks {
+		nb, ok := index[ob.Name]
+		if !ok {
+			continue
+		}
+		x, y := ob.values(unit), nb.values(unit)
+		if len(x) == 0 || len(y) == 0 {
+			continue
+		}
+
+		row := Row{Name: ob.Name, Old: Summarize(x), New: Summarize(y)}
+		row.Delta = (row.New.Mean - row.Old.Mean) / row.Old.Mean
+		row.P = MannWhitney(row.Old.Kept, row.New.Kept)
+		row.Significant = row.P < alpha
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Print writes the comparison in benchstat format. The last line is the geometric
+// mean of the significant changes.
+func Print(w io.Writer, rows []Row, unit string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "name\told %s\tnew %s\tdelta\t\n", unit, unit)
+
+	logSum, n := 0.0, 0
+	for _, r := range rows {
+		delta := "~"
+		if r.Significant {
+			delta = fmt.Sprintf("%+.2f%%", r.Delta*100)
+			logSum += math.Log(r.New.Mean / r.Old.Mean)
+			n++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t(p=%.3f n=%d+%d)\n", r.Name, r.Old, r.New, delta, r.P, len(r.Old.Kept), len(r.New.Kept))
+	}
+	if n > 0 {
+		fmt.Fprintf(tw, "[Geo mean of %d changes]\t\t\t%+.2f%%\t\n", n, (math.Exp(logSum/float64(n))-1)*100)
+	}
+	return tw.Flush()
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  hash-bench save [bench.txt]          parse go test -bench output (stdin by default) and print JSON")
+	fmt.Fprintln(os.Stderr, "  hash-bench compare old.json new.json compare two saved runs")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	var (
+		unit  = flag.String("metric", "ns/op", "metric to compare, e.g. ns/op or MB/s")
+		alpha = flag.Float64("alpha", 0.05, "significance level of the Mann-Whitney U test")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	var err error
+	switch flag.Arg(0) {
+	case "save":
+		err = save(flag.Arg(1), os.Stdout)
+	case "compare":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		err = compare(flag.Arg(1), flag.Arg(2), *unit, *alpha, os.Stdout)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func save(path string, w io.Writer) error {
+	in := io.Reader(os.Stdin)
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	run, err := Parse(in)
+	if err != nil {
+		return err
+	}
+	if len(run.Benchmarks) == 0 {
+		return fmt.Errorf("no benchmark results found")
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(run)
+}
+
+func load(path string) (*Run, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var run Run
+	if err := json.NewDecoder(file).Decode(&run); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &run, nil
+}
+
+func compare(oldPath, newPath, unit string, alpha float64, w io.Writer) error {
+	old, err := load(oldPath)
+	if err != nil {
+		return err
+	}
+	new, err := load(newPath)
+	if err != nil {
+		return err
+	}
+
+	// Results are still printed when the configurations differ, with a warning.
+	keys := make([]string, 0, len(old.Config))
+	for k := range old.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := new.Config[k]; ok && v != old.Config[k] {
+			fmt.Fprintf(os.Stderr, "warning: %s differs: %q vs %q\n", k, old.Config[k], v)
+		}
+	}
+
+	rows := Compare(old, new, unit, alpha)
+	if len(rows) == 0 {
+		return fmt.Errorf("no common benchmarks with metric %s", unit)
+	}
+	return Print(w, rows, unit)
+}
@@ -0,0 +1,131 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/10        Feng Yifei
+ */
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: example
+BenchmarkHash/sha256/keyed/32B-8   	 1000000	      1052 ns/op	  30.42 MB/s
+BenchmarkHash/sha256/keyed/32B-8   	 1000000	      1048 ns/op	  30.53 MB/s
+BenchmarkHash/fnv64a/unkeyed/16B-8 	50000000	      25.1 ns/op
+PASS
+ok  	example	3.012s
+`
+
+func TestParse(t *testing.T) {
+	run, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Config["goarch"] != "amd64" || run.Config["pkg"] != "example" {
+		t.Fatalf("config = %v", run.Config)
+	}
+	if len(run.Benchmarks) != 2 {
+		t.Fatalf("%d benchmarks, want 2", len(run.Benchmarks))
+	}
+
+	b := run.Benchmarks[0]
+	if b.Name != "Hash/sha256/keyed/32B" || len(b.Samples) != 2 {
+		t.Fatalf("benchmark = %+v", b)
+	}
+	if s := b.Samples[1]; s.Iterations != 1000000 || s.Metrics["ns/op"] != 1048 || s.Metrics["MB/s"] != 30.53 {
+		t.Fatalf("sample = %+v", s)
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	for _, c := range []struct {
+		x, y []float64
+		p    float64
+	}{
+		// 3+3 fully separated samples: only 2 of the 20 arrangements are as extreme.
+		{[]float64{1, 2, 3}, []float64{4, 5, 6}, 0.1},
+		{[]float64{1, 3, 5}, []float64{2, 4, 6}, 0.7},
+		{[]float64{1, 1, 1}, []float64{1, 1, 1}, 1},
+	} {
+		if p := MannWhitney(c.x, c.y); math.Abs(p-c.p) > 1e-9 {
+			t.Errorf("MannWhitney(%v, %v) = %f, want %f", c.x, c.y, p, c.p)
+		}
+	}
+
+	// Larger samples use the normal approximation; clearly different groups are significant.
+	var x, y []float64
+	for i := 0; i < 40; i++ {
+		x = append(x, 100+float64(i%5))
+		y = append(y, 110+float64(i%5))
+	}
+	if p := MannWhitney(x, y); p > 1e-6 {
+		t.Errorf("p = %g for clearly different samples", p)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]float64{100, 101, 99, 100, 500})
+	if len(s.Kept) != 4 || s.Mean != 100 || s.Spread != 0.01 {
+		t.Fatalf("summary = %+v", s)
+	}
+	if got := s.String(); got != "100 ± 1%" {
+		t.Fatalf("String() = %q", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := &Run{Benchmarks: []*Benchmark{{Name: "a"}, {Name: "b"}}}
+	new := &Run{Benchmarks: []*Benchmark{{Name: "a"}, {Name: "b"}}}
+	for i := 0; i < 6; i++ {
+		old.Benchmarks[0].Samples = append(old.Benchmarks[0].Samples, &Sample{Metrics: map[string]float64{"ns/op": 100 + float64(i)}})
+		new.Benchmarks[0].Samples = append(new.Benchmarks[0].Samples, &Sample{Metrics: map[string]float64{"ns/op": 50 + float64(i)}})
+		old.Benchmarks[1].Samples = append(old.Benchmarks[1].Samples, &Sample{Metrics: map[string]float64{"ns/op": 100 + float64(i)}})
+		new.Benchmarks[1].Samples = append(new.Benchmarks[1].Samples, &Sample{Metrics: map[string]float64{"ns/op": 100 + float64(5-i)}})
+	}
+
+	rows := Compare(old, new, "ns/op", 0.05)
+	if len(rows) != 2 || !rows[0].Significant || rows[1].Significant {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if math.Abs(rows[0].Delta+0.4878) > 1e-3 {
+		t.Fatalf("delta = %f", rows[0].Delta)
+	}
+
+	var out strings.Builder
+	if err := Print(&out, rows, "ns/op"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-48.78%") || !strings.Contains(out.String(), "[Geo mean of 1 changes]") {
+		t.Fatalf("output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/10        Feng Yifei
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Summary describes a set of measurements with the outliers removed.
+type Summary struct {
+	Mean float64
+	// Spread is the largest deviation from the mean after removing outliers,
+	// relative to the mean.
+	Spread float64
+	// Kept holds the measurements left after removing outliers.
+	Kept []float64
+}
+
+// Summarize removes outliers outside [Q1-1.5*IQR, Q3+1.5*IQR] and computes the
+// mean and spread of the rest.
+func Summarize(values []float64) Summary {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	q1, q3 := quantile(sorted, 0.25), quantile(sorted, 0.75)
+	lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+
+	var s Summary
+	for _, v := range sorted {
+		if v >= lo && v <= hi {
+			s.Kept = append(s.Kept, v)
+			s.Mean += v
+		}
+	}
+	s.Mean /= float64(len(s.Kept))
+
+	for _, v := range s.Kept {
+		if d := math.Abs(v-s.Mean) / s.Mean; d > s.Spread {
+			s.Spread = d
+		}
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%s ±%2.0f%%", format(s.Mean), s.Spread*100)
+}
+
+// format keeps three significant digits, using k, M and G suffixes from 1000 up.
+func format(v float64) string {
+	suffix := ""
+	for _, s := range []string{"k", "M", "G"} {
+		if v < 1000 {
+			break
+		}
+		v /= 1000
+		suffix = s
+	}
+
+	switch {
+	case v >= 100:
+		return fmt.Sprintf("%.0f%s", v, suffix)
+	case v >= 10:
+		return fmt.Sprintf("%.1f%s", v, suffix)
+	case v >= 1:
+		return fmt.Sprintf("%.2f%s", v, suffix)
+	}
+	return fmt.Sprintf("%.3g%s", v, suffix)
+}
+
+// quantile returns the q-quantile of sorted using linear interpolation.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// exactLimit is the largest total sample count for which the exact distribution
+// of U is used when there are no ties.
+const exactLimit = 50
+
+// MannWhitney returns the two-sided p-value of the Mann-Whitney U test. Small
+// samples without ties use the exact distribution; otherwise the normal
+// approximation with continuity and tie correction is used. It returns 1 when
+// either group is empty or all values are equal.
+func MannWhitney(x, y []float64) float64 {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if len(x) == 0 || len(y) == 0 {
+		return 1
+	}
+
+	type obs struct {
+		v     float64
+		first bool
+	}
+	all := make([]obs, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Ties get their average rank and add to the tie correction.
+	var rank1, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rank1 += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n := n1 + n2
+	u := rank1 - n1*(n1+1)/2
+	if ties == 0 && len(all) <= exactLimit {
+		return exactP(len(x), len(y), int(u))
+	}
+
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+
+	z := math.Abs(u-mean) - 0.5
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt(variance) / math.Sqrt2)
+}
+
+// exactP returns the exact two-sided p-value of U without ties.
+func exactP(n1, n2, u int) float64 {
+	// counts[i][j][k] is the number of arrangements of i and j samples with U equal
+	// to k; only a rolling slice of the i dimension is kept.
+	top := n1 * n2
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = make([]float64, top+1)
+		prev[j][0] = 1
+	}
+	for i := 1; i <= n1; i++ {
+		cur := make([][]float64, n2+1)
+		cur[0] = make([]float64, top+1)
+		cur[0][0] = 1
+		for j := 1; j <= n2; j++ {
+			cur[j] = make([]float64, top+1)
+			for k := 0; k <= i*j; k++ {
+				// U grows by j when the largest value is in the first group and is
+				// unchanged when it is in the second.
+				if k >= j {
+					cur[j][k] += prev[j][k-j]
+				}
+				cur[j][k] += cur[j-1][k]
+			}
+		}
+		prev = cur
+	}
+
+	total, below, above := 0.0, 0.0, 0.0
+	for k, c := range prev[n2] {
+		total += c
+		if k <= u {
+			below += c
+		}
+		if k >= u {
+			above += c
+		}
+	}
+	return math.Min(1, 2*math.Min(below, above)/total)
+}
@@ -0,0 +1,233 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/06        Feng Yifei
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"hash/fnv"
+
+	"github.com/aead/poly1305"
+	aeadsiphash "github.com/aead/siphash"
+	"github.com/dchest/siphash"
+	blake2bsimd "github.com/minio/blake2b-simd"
+	"github.com/minio/highwayhash"
+	sha256simd "github.com/minio/sha256-simd"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// Algorithm describes a hash function usable by the permutation tests and the benchmarks.
+type Algorithm struct {
+	// Name is used on the command line and in the output.
+	Name string
+	// KeySize is the key length in bytes, 0 for unkeyed hashes.
+	KeySize int
+	// Size is the output length in bytes.
+	Size int
+	// New creates the hash; len(key) is KeySize.
+	New func(key []byte) (hash.Hash, error)
+}
+
+// Sum hashes buf. Only the first KeySize bytes of key are used.
+func (a Algorithm) Sum(buf, key []byte) ([]byte, error) {
+	if len(key) < a.KeySize {
+		return nil, fmt.Errorf("%s: key is %d bytes, want %d", a.Name, len(key), a.KeySize)
+	}
+
+	h, err := a.New(key[:a.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	h.Write(buf)
+	return h.Sum(nil), nil
+}
+
+var (
+	registry = make(map[string]Algorithm)
+	ordered  []string // names in registration order
+)
+
+// Register adds a to the registry. It panics on a duplicate name or an incomplete Algorithm.
+func Register(a Algorithm) {
+	if a.Name == "" || a.New == nil || a.Size <= 0 || a.KeySize < 0 {
+		panic(fmt.Sprintf("hash-compare: invalid algorithm %+v", a))
+	}
+	if _, ok := registry[a.Name]; ok {
+		panic("hash-compare: algorithm " + a.Name + " registered twice")
+	}
+
+	registry[a.Name] = a
+	ordered = append(ordered, a.Name)
+}
+
+// Lookup returns the algorithm registered as name.
+func Lookup(name string) (Algorithm, bool) {
+	a, ok := registry[name]
+	return a, ok
+}
+
+// Algorithms returns all algorithms in registration order.
+func Algorithms() []Algorithm {
+	all := make([]Algorithm, len(ordered))
+	for i, name := range ordered {
+		all[i] = registry[name]
+	}
+	return all
+}
+
+// unkeyed adapts a constructor without a key to Algorithm.New.
+func unkeyed(fn func() hash.Hash) func([]byte) (hash.Hash, error) {
+	return func([]byte) (hash.Hash, error) {
+		return fn(), nil
+	}
+}
+
+// keyed adapts a keyed constructor that cannot fail to Algorithm.New.
+func keyed[H hash.Hash](fn func(key []byte) H) func([]byte) (hash.Hash, error) {
+	return func(key []byte) (hash.Hash, error) {
+		return fn(key), nil
+	}
+}
+
+// poly1305Hash adds Reset and BlockSize to poly1305.Hash. Poly1305 is a one-time MAC,
+// so Reset starts over with the same key. The vendored implementation overruns its
+// buffer when several writes exactly fill a block, so only whole blocks are written
+// to it and the remainder is written by Sum.
+type poly1305Hash struct {
+	*poly1305.Hash
+	key [32]byte
+	buf [poly1305.TagSize]byte
+	off int
+}
+
+func newPoly1305(key []byte) (hash.Hash, error) {
+	h := &poly1305Hash{}
+	copy(h.key[:], key)
+	h.Reset()
+	return h, nil
+}
+
+func (h *poly1305Hash) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if h.off > 0 {
+		c := copy(h.buf[h.off:], p)
+		h.off += c
+		p = p[c:]
+		if h.off < len(h.buf) {
+			return n, nil
+		}
+		if _, err := h.Hash.Write(h.buf[:]); err != nil {
+			return 0, err
+		}
+		h.off = 0
+	}
+
+	if full := len(p) &^ (poly1305.TagSize - 1); full > 0 {
+		if _, err := h.Hash.Write(p[:full]); err != nil {
+			return 0, err
+		}
+		p = p[full:]
+	}
+	h.off = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *poly1305Hash) Sum(b []byte) []byte {
+	if h.off > 0 {
+		h.Hash.Write(h.buf[:h.off])
+		h.off = 0
+	}
+	return h.Hash.Sum(b)
+}
+
+func (h *poly1305Hash) Reset() {
+	h.Hash = poly1305.New(h.key)
+	h.off = 0
+}
+
+func (h *poly1305Hash) BlockSize() int {
+	return poly1305.TagSize
+}
+
+func init() {
+	for _, a := range []Algorithm{
+		// the original permutation test set
+		{Name: "blake2b", Size: 64, New: unkeyed(blake2bsimd.New512)},
+		{Name: "blake2b-256", Size: 32, New: unkeyed(blake2bsimd.New256)},
+		{Name: "poly1305", KeySize: 32, Size: poly1305.TagSize, New: newPoly1305},
+		{Name: "siphash", KeySize: aeadsiphash.KeySize, Size: 16, New: aeadsiphash.New128},
+		{Name: "highwayhash256", KeySize: 32, Size: highwayhash.Size, New: highwayhash.New},
+		{Name: "highwayhash128", KeySize: 32, Size: highwayhash.Size128, New: highwayhash.New128},
+		{Name: "highwayhash64", KeySize: 32, Size: highwayhash.Size64, New: func(key []byte) (hash.Hash, error) { return highwayhash.New64(key) }},
+
+		// vendored but previously unused
+		{Name: "siphash64", KeySize: aeadsiphash.KeySize, Size: 8, New: func(key []byte) (hash.Hash, error) { return aeadsiphash.New64(key) }},
+		{Name: "dchest-siphash", KeySize: 16, Size: siphash.Size, New: keyed(siphash.New)},
+		{Name: "dchest-siphash128", KeySize: 16, Size: siphash.Size128, New: keyed(siphash.New128)},
+		{Name: "blake2b-keyed", KeySize: 32, Size: blake2b.Size, New: blake2b.New512},
+		{Name: "blake2s", Size: blake2s.Size, New: blake2s.New256},
+		{Name: "blake2s-keyed", KeySize: 32, Size: blake2s.Size, New: blake2s.New256},
+		{Name: "sha256-simd", Size: sha256simd.Size, New: unkeyed(sha256simd.New)},
+		{Name: "sha3-224", Size: 28, New: unkeyed(sha3.New224)},
+		{Name: "sha3-256", Size: 32, New: unkeyed(sha3.New256)},
+		{Name: "sha3-384", Size: 48, New: unkeyed(sha3.New384)},
+		{Name: "sha3-512", Size: 64, New: unkeyed(sha3.New512)},
+
+		// standard library
+		{Name: "md5", Size: md5.Size, New: unkeyed(md5.New)},
+		{Name: "sha1", Size: sha1.Size, New: unkeyed(sha1.New)},
+		{Name: "sha224", Size: sha256.Size224, New: unkeyed(sha256.New224)},
+		{Name: "sha256", Size: sha256.Size, New: unkeyed(sha256.New)},
+		{Name: "sha384", Size: sha512.Size384, New: unkeyed(sha512.New384)},
+		{Name: "sha512", Size: sha512.Size, New: unkeyed(sha512.New)},
+		{Name: "sha512-256", Size: sha512.Size256, New: unkeyed(sha512.New512_256)},
+		{Name: "fnv32", Size: 4, New: unkeyed(func() hash.Hash { return fnv.New32() })},
+		{Name: "fnv32a", Size: 4, New: unkeyed(func() hash.Hash { return fnv.New32a() })},
+		{Name: "fnv64", Size: 8, New: unkeyed(func() hash.Hash { return fnv.New64() })},
+		{Name: "fnv64a", Size: 8, New: unkeyed(func() hash.Hash { return fnv.New64a() })},
+		{Name: "fnv128", Size: 16, New: unkeyed(fnv.New128)},
+		{Name: "fnv128a", Size: 16, New: unkeyed(fnv.New128a)},
+		{Name: "crc32", Size: crc32.Size, New: unkeyed(func() hash.Hash { return crc32.NewIEEE() })},
+		{Name: "crc32c", Size: crc32.Size, New: unkeyed(func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) })},
+		{Name: "crc64-iso", Size: crc64.Size, New: unkeyed(func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ISO)) })},
+		{Name: "crc64-ecma", Size: crc64.Size, New: unkeyed(func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) })},
+	} {
+		Register(a)
+	}
+}
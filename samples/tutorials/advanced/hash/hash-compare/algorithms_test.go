@@ -0,0 +1,82 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/06        Feng Yifei
+ */
+
+package main_test
+
+import (
+	"testing"
+
+	compare "github.com/TechCatsLab/gosnippet/samples/tutorials/advanced/hash/hash-compare"
+)
+
+func TestAlgorithms(t *testing.T) {
+	var key [64]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	for _, a := range compare.Algorithms() {
+		if got, ok := compare.Lookup(a.Name); !ok || got.Name != a.Name {
+			t.Fatalf("Lookup(%q) = %v, %v", a.Name, got.Name, ok)
+		}
+
+		sum, err := a.Sum([]byte("hash-compare"), key[:])
+		if err != nil {
+			t.Fatalf("%s: %v", a.Name, err)
+		}
+		if len(sum) != a.Size {
+			t.Errorf("%s: sum is %d bytes, declared %d", a.Name, len(sum), a.Size)
+		}
+
+		// Hashing again gives the same sum; keyed hashes change with the key.
+		again, _ := a.Sum([]byte("hash-compare"), key[:])
+		if string(again) != string(sum) {
+			t.Errorf("%s: sum is not deterministic", a.Name)
+		}
+		// The permutation test writes the message in three parts; the sum must match a single write.
+		h, _ := a.New(key[:a.KeySize])
+		h.Write([]byte("hash"))
+		h.Write([]byte("-"))
+		h.Write([]byte("compare"))
+		if split := h.Sum(nil); string(split) != string(sum) {
+			t.Errorf("%s: split writes give %x, want %x", a.Name, split, sum)
+		}
+		if a.KeySize > 0 {
+			other, _ := a.Sum([]byte("hash-compare"), key[1:])
+			if string(other) == string(sum) {
+				t.Errorf("%s: sum does not depend on the key", a.Name)
+			}
+		}
+	}
+
+	poly, _ := compare.Lookup("poly1305")
+	if _, err := poly.Sum(nil, key[:16]); err == nil {
+		t.Error("short key accepted")
+	}
+}
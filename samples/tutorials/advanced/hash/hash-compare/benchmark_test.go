@@ -17,69 +17,90 @@
 package main_test
 
 import (
-	"crypto/md5"
+	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
 	"hash"
+	"strconv"
 	"testing"
 
-	"github.com/minio/highwayhash"
-	"golang.org/x/crypto/blake2b"
+	compare "github.com/TechCatsLab/gosnippet/samples/tutorials/advanced/hash/hash-compare"
 )
 
-const size = 5 * 1024 * 1024
+// sizes covers the 32-256 byte keys we hash most often as well as bulk data
+var sizes = []int{16, 32, 64, 128, 256, 1 << 10, 4 << 10, 64 << 10, 1 << 20, 16 << 20}
+
+var data = func() []byte {
+	buf := make([]byte, sizes[len(sizes)-1])
+	rand.Read(buf)
+	return buf
+}()
+
+// BenchmarkHash runs every registered algorithm on every size in two modes:
+//
+//	unkeyed: keyed algorithms use an all-zero key
+//	keyed:   keyed algorithms use a random key, the others are wrapped in HMAC
+//
+// Select a subset with e.g. -bench 'Hash/siphash/keyed/(32|256)$' and save the
+// output with the hash-bench command to compare runs.
+func BenchmarkHash(b *testing.B) {
+	for _, a := range compare.Algorithms() {
+		for _, mode := range []string{"unkeyed", "keyed"} {
+			newHash := hasher(a, mode)
+			for _, size := range sizes {
+				b.Run(a.Name+"/"+mode+"/"+sizeName(size), func(b *testing.B) {
+					benchmarkHash(b, newHash, data[:size])
+				})
+			}
+		}
+	}
+}
 
-func benchmarkHashWithKey(b *testing.B, hash func(key []byte) (hash.Hash, error)) {
-	var key [32]byte
-	data := make([]byte, size)
-	rand.Read(data)
+// hasher returns a constructor for algorithm a in the given mode
+func hasher(a compare.Algorithm, mode string) func() (hash.Hash, error) {
+	key := make([]byte, a.KeySize)
+	if mode == "unkeyed" {
+		return func() (hash.Hash, error) { return a.New(key) }
+	}
 
-	b.SetBytes(size)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		h, _ := hash(key[:])
-		h.Write(data)
-		h.Sum(nil)
+	if a.KeySize == 0 {
+		key = make([]byte, 32)
+	}
+	rand.Read(key)
+
+	if a.KeySize == 0 {
+		return func() (hash.Hash, error) {
+			return hmac.New(func() hash.Hash {
+				h, _ := a.New(nil)
+				return h
+			}, key), nil
+		}
 	}
+	return func() (hash.Hash, error) { return a.New(key) }
 }
 
-func benchmarkHash(b *testing.B, hash func() hash.Hash) {
-	data := make([]byte, size)
-	rand.Read(data)
-
-	b.SetBytes(size)
+// benchmarkHash creates a hash per message, so key setup is part of the cost
+// like it is when hashing many short keys
+func benchmarkHash(b *testing.B, newHash func() (hash.Hash, error), msg []byte) {
+	b.SetBytes(int64(len(msg)))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		h := hash()
-		h.Write(data)
+		h, err := newHash()
+		if err != nil {
+			b.Fatal(err)
+		}
+		h.Write(msg)
 		h.Sum(nil)
 	}
 }
 
-func BenchmarkHighwayHash(b *testing.B) {
-	benchmarkHashWithKey(b, highwayhash.New)
-}
-
-func BenchmarkBlake2b(b *testing.B) {
-	benchmarkHashWithKey(b, blake2b.New512)
-}
-
-func BenchmarkSHA1(b *testing.B) {
-	benchmarkHash(b, sha1.New)
-}
-
-func BenchmarkMD5(b *testing.B) {
-	benchmarkHash(b, md5.New)
-}
-
-func BenchmarkSHA512(b *testing.B) {
-	benchmarkHash(b, sha512.New)
-}
-
-func BenchmarkSHA256(b *testing.B) {
-	benchmarkHash(b, sha256.New)
+func sizeName(size int) string {
+	switch {
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return strconv.Itoa(size>>20) + "MiB"
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return strconv.Itoa(size>>10) + "KiB"
+	}
+	return strconv.Itoa(size) + "B"
 }
 
 // AVX512 code below
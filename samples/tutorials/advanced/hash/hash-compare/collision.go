@@ -0,0 +1,597 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/10        Feng Yifei
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collisions holds the parameters of the truncated collision test.
+type Collisions struct {
+	// Bits is the number of leading output bits kept, 1 to 64.
+	Bits int
+	// Inputs is flips, counter or words.
+	Inputs string
+	// Limit is the most inputs tested; 0 means 4*2^(Bits/2), about 8 expected collisions.
+	Limit int64
+	// InputSize is the length in bytes of flips and counter inputs.
+	InputSize int
+	// Words is the word list of words inputs; duplicates keep the first.
+	Words []string
+	// Pairs is the most collision pairs reported.
+	Pairs int
+	// Alpha is the significance level; the test fails when there are more collisions
+	// than expected and their upper tail probability is below Alpha.
+	Alpha float64
+	// Spill bounds the memory used to sort the truncated outputs.
+	Spill Spill
+}
+
+// DefaultCollisions truncates to 32 bits and hashes 32 byte counters.
+var DefaultCollisions = Collisions{Bits: 32, Inputs: "counter", InputSize: 32, Pairs: 10, Alpha: 0.001, Spill: DefaultSpill}
+
+// maxInputs is the largest input count, since input indexes are stored as uint32.
+const maxInputs = math.MaxUint32
+
+// Pair is two inputs with the same truncated output; First comes before Second.
+type Pair struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+	// Tag is the truncated output in hex.
+	Tag string `json:"tag"`
+}
+
+// CollisionReport is the result of the truncated collision test of one algorithm.
+type CollisionReport struct {
+	Algorithm string `json:"algorithm"`
+	Bits      int    `json:"bits"`
+	Inputs    string `json:"inputs"`
+	// Tested is the number of inputs hashed, below Limit when fewer inputs are available.
+	Tested int64 `json:"tested"`
+	// Collisions counts pairs of inputs with the same truncated output; k equal
+	// inputs count as k*(k-1)/2 pairs.
+	Collisions int64 `json:"collisions"`
+	// Expected is the number of pairs a random function gives, n*(n-1)/2^(Bits+1).
+	Expected float64 `json:"expected"`
+	// P is the probability of at least Collisions pairs from a random function (the
+	// Poisson upper tail).
+	P float64 `json:"p"`
+	// FirstAt is the number of inputs tested when the first pair appeared, 0 without collisions.
+	FirstAt int64 `json:"first_at"`
+	// ExpectedFirst is the expected number of inputs before a random function's first
+	// collision, sqrt(pi/2*2^Bits).
+	ExpectedFirst float64 `json:"expected_first"`
+	Pass          bool    `json:"pass"`
+	// Pairs lists the first Pairs collisions, ordered by their second input.
+	Pairs   []Pair        `json:"pairs"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// inputSource is a sequence of inputs with random access by index. Each worker
+// hashes a contiguous range of indexes.
+type inputSource interface {
+	// Len returns the number of inputs available.
+	Len() int64
+	// At writes input i to buf and returns it, allocating a new buffer when buf is too small.
+	At(i int64, buf []byte) []byte
+	// Describe returns a readable description of input i.
+	Describe(i int64) string
+}
+
+// newInputSource returns the inputs named by c.Inputs; flips generates at most limit inputs.
+func newInputSource(c Collisions, limit int64) (inputSource, error) {
+	switch c.Inputs {
+	case "counter":
+		return counterInputs{size: c.InputSize}, nil
+	case "flips":
+		return newFlipInputs(c.InputSize, limit), nil
+	case "words":
+		if len(c.Words) == 0 {
+			return nil, fmt.Errorf("no words for words inputs")
+		}
+		return wordInputs(c.Words), nil
+	}
+	return nil, fmt.Errorf("unknown inputs %q, want flips, counter or words", c.Inputs)
+}
+
+// counterInputs makes input i the counter i written big-endian at the end, the
+// same inputs as the chi-square test.
+type counterInputs struct {
+	size int
+}
+
+func (c counterInputs) Len() int64 {
+	if c.size < 4 {
+		return 1 << uint(8*c.size)
+	}
+	return maxInputs
+}
+
+func (c counterInputs) At(i int64, buf []byte) []byte {
+	buf = resize(buf, c.size)
+	counter(buf, uint64(i))
+	return buf
+}
+
+func (c counterInputs) Describe(i int64) string {
+	return fmt.Sprintf("counter %d", i)
+}
+
+// flipInputs sets 1, 2, 3, ... bits of an all-zero input, enumerating every
+// combination in turn: sparse inputs by increasing Hamming weight.
+type flipInputs struct {
+	size   int
+	counts []int64 // counts[w-1] is the number of inputs with w bits set
+	total  int64
+}
+
+// newFlipInputs adds weights until there are at least limit combinations.
+func newFlipInputs(size int, limit int64) *flipInputs {
+	f := &flipInputs{size: size}
+	n := 8 * size
+	for w := 1; w <= n && f.total < limit; w++ {
+		c := binomial(n, w)
+		if c > maxInputs {
+			c = maxInputs
+		}
+		f.counts = append(f.counts, c)
+		f.total += c
+	}
+	return f
+}
+
+func (f *flipInputs) Len() int64 {
+	return f.total
+}
+
+func (f *flipInputs) At(i int64, buf []byte) []byte {
+	buf = resize(buf, f.size)
+	for j := range buf {
+		buf[j] = 0
+	}
+	for _, p := range f.positions(i) {
+		buf[p/8] |= 1 << uint(p%8)
+	}
+	return buf
+}
+
+func (f *flipInputs) Describe(i int64) string {
+	return fmt.Sprintf("bits %v", f.positions(i))
+}
+
+// positions returns the bits set in input i, unranking the index within its
+// weight with the combinatorial number system (colex order).
+func (f *flipInputs) positions(i int64) []int {
+	w := 1
+	for _, c := range f.counts {
+		if i < c {
+			break
+		}
+		i -= c
+		w++
+	}
+
+	var (
+		r     = uint64(i)
+		bound = 8 * f.size
+		all   = make([]int, w)
+	)
+	for k := w; k >= 1; k-- {
+		// the largest c below bound with C(c, k) <= r
+		c := sort.Search(bound-k+1, func(x int) bool {
+			return uint64(binomial(x+k, k)) > r
+		}) + k - 1
+		all[k-1] = c
+		r -= uint64(binomial(c, k))
+		bound = c
+	}
+	return all
+}
+
+// binomial returns C(n, k), or maxInputs+1 when it exceeds maxInputs.
+func binomial(n, k int) int64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	c := int64(1)
+	for i := 0; i < k; i++ {
+		c = c * int64(n-i) / int64(i+1)
+		if c > maxInputs {
+			return maxInputs + 1
+		}
+	}
+	return c
+}
+
+// resize returns buf with length n, reallocating it when too small.
+func resize(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// wordInputs makes input i the i-th word of the list.
+type wordInputs []string
+
+func (w wordInputs) Len() int64 {
+	return int64(len(w))
+}
+
+func (w wordInputs) At(i int64, buf []byte) []byte {
+	return append(buf[:0], w[i]...)
+}
+
+func (w wordInputs) Describe(i int64) string {
+	return strconv.Quote(w[i])
+}
+
+// LoadWords reads a word list with one word per line. Blank lines are skipped and
+// duplicates keep the first.
+func LoadWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		words []string
+		seen  = make(map[string]bool)
+		s     = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		word := strings.TrimSpace(s.Text())
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words, s.Err()
+}
+
+// truncatedSize is the size of a sorted record: the truncated output and then the
+// input index, both big-endian, so records sort by output and then by index.
+const truncatedSize = 12
+
+func putTruncated(rec []byte, tag uint64, index uint32) {
+	binary.BigEndian.PutUint64(rec, tag)
+	binary.BigEndian.PutUint32(rec[8:], index)
+}
+
+func getTruncated(rec []byte) (tag uint64, index uint32) {
+	return binary.BigEndian.Uint64(rec), binary.BigEndian.Uint32(rec[8:])
+}
+
+// TestHashCollisions truncates the output of algo to its first c.Bits bits, hashes
+// the first c.Limit inputs, sorts them and counts the pairs with the same truncated
+// output, comparing the count with a random function of the same width. The
+// outputs are sorted within c.Spill.Memory bytes, spilling to temporary files.
+func TestHashCollisions(algo Algorithm, key [32]byte, workers int, c Collisions) (CollisionReport, error) {
+	start := time.Now()
+	report := CollisionReport{Algorithm: algo.Name, Bits: c.Bits, Inputs: c.Inputs}
+
+	if c.Bits < 1 || c.Bits > 64 || c.Bits > algo.Size*8 {
+		return report, fmt.Errorf("%s: cannot truncate %d bit output to %d bits", algo.Name, algo.Size*8, c.Bits)
+	}
+
+	limit := c.Limit
+	if limit <= 0 {
+		limit = int64(4 * math.Exp2(float64(c.Bits)/2))
+	}
+	if limit > maxInputs {
+		limit = maxInputs
+	}
+	source, err := newInputSource(c, limit)
+	if err != nil {
+		return report, err
+	}
+	n := source.Len()
+	if n > limit {
+		n = limit
+	}
+	report.Tested = n
+
+	sorters, err := truncate(algo, key[:algo.KeySize], source, n, c.Bits, workers, c.Spill)
+	defer func() {
+		for _, s := range sorters {
+			s.Close()
+		}
+	}()
+	if err != nil {
+		return report, err
+	}
+	runs, err := mergeRuns(sorters, maxFanIn)
+	if err != nil {
+		return report, err
+	}
+
+	// Within each group of equal outputs, every later input is reported paired with the first.
+	var (
+		first []collision
+		group int64
+		head  collision
+	)
+	err = mergeTags(runs, func(rec []byte) error {
+		tag, index := getTruncated(rec)
+		if group == 0 || tag != head.tag {
+			report.Collisions += group * (group - 1) / 2
+			group, head = 1, collision{tag: tag, first: index}
+			return nil
+		}
+
+		group++
+		first = insertPair(first, collision{tag: tag, first: head.first, second: index}, c.Pairs)
+		if report.FirstAt == 0 || int64(index)+1 < report.FirstAt {
+			report.FirstAt = int64(index) + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Collisions += group * (group - 1) / 2
+
+	width := (c.Bits + 3) / 4
+	for _, p := range first {
+		report.Pairs = append(report.Pairs, Pair{
+			First:  source.Describe(int64(p.first)),
+			Second: source.Describe(int64(p.second)),
+			Tag:    fmt.Sprintf("%0*x", width, p.tag),
+		})
+	}
+
+	space := math.Exp2(float64(c.Bits))
+	report.Expected = float64(n) * float64(n-1) / 2 / space
+	report.ExpectedFirst = math.Sqrt(math.Pi / 2 * space)
+	report.P = poissonUpper(report.Collisions, report.Expected)
+	report.Pass = report.P >= c.Alpha
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// truncate hashes the inputs in workers goroutines, each taking a contiguous range
+// and sorting its records within spill.Memory/workers bytes. The sorters are
+// returned even on error so that the caller can remove their temporary files.
+func truncate(algo Algorithm, key []byte, source inputSource, n int64, bits, workers int, spill Spill) ([]*tagSorter, error) {
+	if int64(workers) > n {
+		workers = int(n)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		sorters = make([]*tagSorter, workers)
+		errs    = make([]error, workers)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		memory := spill.Memory / uint(workers)
+		if size := uint(n/int64(workers)+1) * truncatedSize; size < memory {
+			memory = size
+		}
+		sorters[w] = newTagSorter(truncatedSize, memory, spill.Dir)
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			var (
+				lo  = n * int64(w) / int64(workers)
+				hi  = n * int64(w+1) / int64(workers)
+				buf = make([]byte, 0, 64)
+				rec = make([]byte, truncatedSize)
+			)
+			for i := lo; i < hi; i++ {
+				msg := source.At(i, buf[:cap(buf)])
+				tag, err := algo.Sum(msg, key)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				putTruncated(rec, prefix(tag)>>uint(64-bits), uint32(i))
+				if err := sorters[w].Add(rec); err != nil {
+					errs[w] = err
+					return
+				}
+				buf = msg
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return sorters, err
+		}
+	}
+	return sorters, nil
+}
+
+// collision is the indexes of two inputs with the same truncated output.
+type collision struct {
+	tag           uint64
+	first, second uint32
+}
+
+// insertPair inserts p into first, ordered by the second index, keeping at most keep pairs.
+func insertPair(first []collision, p collision, keep int) []collision {
+	if keep <= 0 || len(first) == keep && p.second >= first[keep-1].second {
+		return first
+	}
+
+	i := sort.Search(len(first), func(i int) bool { return first[i].second > p.second })
+	first = append(first, collision{})
+	copy(first[i+1:], first[i:])
+	first[i] = p
+	if len(first) > keep {
+		first = first[:keep]
+	}
+	return first
+}
+
+// poissonUpper returns the probability that a Poisson variable with mean lambda is at least k.
+func poissonUpper(k int64, lambda float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if lambda <= 0 {
+		return 0
+	}
+
+	term := func(i int64) float64 {
+		lg, _ := math.Lgamma(float64(i) + 1)
+		return math.Exp(float64(i)*math.Log(lambda) - lambda - lg)
+	}
+
+	// Up to the mean the lower tail is the shorter sum, so subtract it from 1;
+	// above the mean sum the upper tail directly, whose terms shrink quickly.
+	if float64(k) <= lambda {
+		lower := 0.0
+		for i := int64(0); i < k; i++ {
+			lower += term(i)
+		}
+		return math.Max(0, 1-lower)
+	}
+
+	upper := 0.0
+	t := term(k)
+	for i := k; t > 0 && t > upper*1e-17; i++ {
+		upper += t
+		t *= lambda / float64(i+1)
+	}
+	return math.Min(1, upper)
+}
+
+// CollisionReporter writes the results of the truncated collision test.
+type CollisionReporter interface {
+	Report(r CollisionReport) error
+	Close() error
+}
+
+// NewCollisionReporter returns a CollisionReporter for the "text", "csv" or "json" format.
+func NewCollisionReporter(w io.Writer, format string) (CollisionReporter, error) {
+	switch format {
+	case "text":
+		return &textCollisions{out: w}, nil
+	case "csv":
+		c := csv.NewWriter(w)
+		err := c.Write([]string{"algorithm", "bits", "inputs", "tested", "collisions", "expected", "p", "first_at", "expected_first", "pass"})
+		if err != nil {
+			return nil, err
+		}
+		return &csvCollisions{w: c}, nil
+	case "json":
+		return &jsonCollisions{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want text, csv or json", format)
+}
+
+// textCollisions writes a summary and the first collision pairs per algorithm.
+type textCollisions struct {
+	out io.Writer
+}
+
+func (t *textCollisions) Report(r CollisionReport) error {
+	verdict := "PASS"
+	if !r.Pass {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(t.out, "\n%s: %s\n", r.Algorithm, verdict)
+	fmt.Fprintf(t.out, "  %d %s inputs truncated to %d bits: %d collisions, expected %.2f, p=%.4g\n",
+		r.Tested, r.Inputs, r.Bits, r.Collisions, r.Expected, r.P)
+	if r.FirstAt > 0 {
+		fmt.Fprintf(t.out, "  first collision after %d inputs, expected after %.0f\n", r.FirstAt, r.ExpectedFirst)
+	} else {
+		fmt.Fprintf(t.out, "  no collision, expected the first after %.0f inputs\n", r.ExpectedFirst)
+	}
+	for _, p := range r.Pairs {
+		fmt.Fprintf(t.out, "  %s  %s = %s\n", p.Tag, p.First, p.Second)
+	}
+	return nil
+}
+
+func (t *textCollisions) Close() error {
+	return nil
+}
+
+// csvCollisions writes a row per algorithm, without the pairs.
+type csvCollisions struct {
+	w *csv.Writer
+}
+
+func (c *csvCollisions) Report(r CollisionReport) error {
+	c.w.Write([]string{
+		r.Algorithm,
+		strconv.Itoa(r.Bits),
+		r.Inputs,
+		strconv.FormatInt(r.Tested, 10),
+		strconv.FormatInt(r.Collisions, 10),
+		strconv.FormatFloat(r.Expected, 'g', -1, 64),
+		strconv.FormatFloat(r.P, 'g', -1, 64),
+		strconv.FormatInt(r.FirstAt, 10),
+		strconv.FormatFloat(r.ExpectedFirst, 'g', -1, 64),
+		strconv.FormatBool(r.Pass),
+	})
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvCollisions) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonCollisions writes a JSON line per algorithm.
+type jsonCollisions struct {
+	enc *json.Encoder
+}
+
+func (j *jsonCollisions) Report(r CollisionReport) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonCollisions) Close() error {
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/10        Feng Yifei
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFlipInputs(t *testing.T) {
+	f := newFlipInputs(2, 100)
+	// 16 input bits: 16 inputs of weight 1 and 120 of weight 2
+	if f.Len() != 136 {
+		t.Fatalf("Len() = %d, want 136", f.Len())
+	}
+
+	seen := make(map[string]bool)
+	weight := 0
+	for i := int64(0); i < f.Len(); i++ {
+		msg := f.At(i, make([]byte, 0, 2))
+		if seen[string(msg)] {
+			t.Fatalf("input %d %x repeated", i, msg)
+		}
+		seen[string(msg)] = true
+
+		w := 0
+		for _, b := range msg {
+			for ; b != 0; b &= b - 1 {
+				w++
+			}
+		}
+		if w < weight {
+			t.Fatalf("input %d has weight %d after weight %d", i, w, weight)
+		}
+		weight = w
+		if len(f.positions(i)) != w {
+			t.Fatalf("input %d: positions %v, weight %d", i, f.positions(i), w)
+		}
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	var key [32]byte
+	c := Collisions{Bits: 16, Inputs: "counter", InputSize: 8, Limit: 2000, Pairs: 3, Alpha: 0.001, Spill: DefaultSpill}
+
+	sha, _ := Lookup("sha256")
+	report, err := TestHashCollisions(sha, key, 3, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tested != 2000 || math.Abs(report.Expected-2000*1999/2/65536.0) > 1e-9 {
+		t.Fatalf("tested %d expected %f", report.Tested, report.Expected)
+	}
+	if !report.Pass || report.Collisions == 0 || len(report.Pairs) != 3 {
+		t.Fatalf("sha256: %+v", report)
+	}
+
+	// Recompute the first reported pair.
+	var a, b uint64
+	source := counterInputs{size: 8}
+	for i := int64(0); i < report.FirstAt; i++ {
+		if source.Describe(i) == report.Pairs[0].First {
+			a = prefix(mustSum(t, sha, source.At(i, nil))) >> 48
+		}
+	}
+	b = prefix(mustSum(t, sha, source.At(report.FirstAt-1, nil))) >> 48
+	if source.Describe(report.FirstAt-1) != report.Pairs[0].Second || a != b {
+		t.Fatalf("first pair %+v does not collide at %d", report.Pairs[0], report.FirstAt)
+	}
+
+	// Spilling to many small runs gives the same collisions.
+	dir, err := ioutil.TempDir("", "hash-compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spilled := c
+	spilled.Spill = Spill{Memory: 64 * truncatedSize, Dir: dir}
+	again, err := TestHashCollisions(sha, key, 3, spilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Collisions != report.Collisions || again.FirstAt != report.FirstAt || !reflect.DeepEqual(again.Pairs, report.Pairs) {
+		t.Fatalf("spilled: %+v, want %+v", again, report)
+	}
+	if left, _ := ioutil.ReadDir(dir); len(left) != 0 {
+		t.Fatalf("%d temporary files left", len(left))
+	}
+
+	// Sparse inputs differing only in the last bytes barely reach FNV's high bits.
+	fnv, _ := Lookup("fnv64")
+	c.Inputs, c.Bits, c.InputSize = "flips", 24, 32
+	report, err = TestHashCollisions(fnv, key, 3, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pass {
+		t.Fatalf("fnv64 passed with %d collisions, expected %f", report.Collisions, report.Expected)
+	}
+
+	crc, _ := Lookup("crc32")
+	c.Bits = 48
+	if _, err := TestHashCollisions(crc, key, 3, c); err == nil {
+		t.Fatal("crc32 truncated to 48 bits")
+	}
+}
+
+func mustSum(t *testing.T, algo Algorithm, msg []byte) []byte {
+	var key [32]byte
+	tag, err := algo.Sum(msg, key[:algo.KeySize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tag
+}
+
+func TestPoissonUpper(t *testing.T) {
+	for _, c := range []struct {
+		k      int64
+		lambda float64
+		want   float64
+	}{
+		{0, 8, 1},
+		{1, 8, 1 - math.Exp(-8)},
+		{3, 1, 1 - math.Exp(-1)*2.5},
+		{20, 8, 0.000252939},
+		{5, 0, 0},
+	} {
+		if got := poissonUpper(c.k, c.lambda); math.Abs(got-c.want) > 1e-6 {
+			t.Errorf("poissonUpper(%d, %g) = %g, want %g", c.k, c.lambda, got, c.want)
+		}
+	}
+
+	if !bytes.Equal(wordInputs{"a", "bc"}.At(1, nil), []byte("bc")) {
+		t.Error("word input")
+	}
+}
@@ -17,294 +17,585 @@
 package main
 
 import (
-	"container/heap"
+	"crypto/rand"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"math/big"
-	"math/rand"
+	"io"
 	"os"
 	"runtime"
-	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"text/tabwriter"
 	"time"
-
-	"github.com/aead/poly1305"
-	"github.com/aead/siphash"
-	"github.com/minio/blake2b-simd"
-	"github.com/minio/highwayhash"
 )
 
-func Poly1305Sum(buf []byte, key [32]byte) []byte {
-	h := poly1305.New(key)
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
+func mask(b, m int) byte {
+	s := uint(9 - m)
+	return byte(((1 << s) - 1) << uint(b%m))
 }
 
-func Blake2bSum(buf []byte) []byte {
-	h := blake2b.New512()
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
-}
+// TestHashPermutationsRange hashes the bit flips taken from space in chunks
+// until none are left. msg is shared by all workers and never modified: the
+// flipped byte is written to the hash separately.
+func TestHashPermutationsRange(msg []byte, key [32]byte, space *flipSpace, algo Algorithm, tags *tagSorter) error {
+	var (
+		flipped [1]byte
+		tag     = make([]byte, 0, algo.Size)
+	)
+
+	for {
+		start, end, ok := space.take()
+		if !ok {
+			return nil
+		}
 
-func Blake2b256Sum(buf []byte) []byte {
-	h := blake2b.New256()
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
-}
+		m, b := space.at(start)
+		for g := start; g < end; g++ {
+			if b == len(msg)*m {
+				m, b = m-1, 0
+			}
+
+			// Change message with mask
+			i := b / m
+			flipped[0] = msg[i] ^ mask(b, m)
+			b++
+
+			h, err := algo.New(key[:algo.KeySize])
+			if err != nil {
+				space.abort()
+				return err
+			}
+			h.Write(msg[:i])
+			h.Write(flipped[:])
+			h.Write(msg[i+1:])
 
-func SipHashSum(buf []byte, key [32]byte) []byte {
-	h, _ := siphash.New128(key[0:16])
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
+			if err := tags.Add(h.Sum(tag[:0])); err != nil {
+				space.abort()
+				return err
+			}
+		}
+	}
 }
 
-func HighwayHash(buf []byte, key [32]byte) []byte {
-	h, _ := highwayhash.New(key[:])
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
+// TestHashPermutations hashes every single byte change of a msgSize message and
+// reports the number of leading zero bits of the smallest gap between sorted tags.
+// Any number of workers take chunks of the bit flips; each one sorts its tags
+// within spill.Memory/workers bytes and spills sorted runs to temporary files,
+// which are then merged by streaming.
+func TestHashPermutations(key [32]byte, msgSize uint, algo Algorithm, workers int, spill Spill) (permutations, zeroBits int, elapsed time.Duration, err error) {
+	start := time.Now()
+	fmt.Fprintln(os.Stderr, "Starting", algo.Name, msgSize, "bytes", start.Format("3:04PM"), "...")
+
+	msg := make([]byte, msgSize)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	space := newFlipSpace(len(msg), defaultChunk)
+
+	cpus := workers
+	sorters := make([]*tagSorter, cpus)
+	errs := make([]error, cpus)
+	defer func() {
+		for _, s := range sorters {
+			s.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for cpu := 0; cpu < cpus; cpu++ {
+		sorters[cpu] = newTagSorter(algo.Size, spill.Memory/uint(cpus), spill.Dir)
+
+		wg.Add(1)
+		go func(cpu int) {
+			defer wg.Done()
+			errs[cpu] = TestHashPermutationsRange(msg, key, space, algo, sorters[cpu])
+		}(cpu)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	runs, err := mergeRuns(sorters, maxFanIn)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	permutations, gap, err := smallestGap(runs, algo.Size)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	elapsed = time.Since(start)
+	fmt.Fprintf(os.Stderr, "smallest gap %x\n", gap)
+	return permutations, leadingZeroBits(gap), elapsed, nil
 }
 
-func HighwayHash128(buf []byte, key [32]byte) []byte {
-	h, _ := highwayhash.New128(key[:])
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
+// defaultAlgorithms are the algorithms permuted when no -algos flag is given
+const defaultAlgorithms = "blake2b,blake2b-256,poly1305,siphash,highwayhash256,highwayhash128,highwayhash64"
+
+// Result is the outcome of permuting all bits of one message size
+type Result struct {
+	Algorithm    string        `json:"algorithm"`
+	Size         uint          `json:"size"`
+	Permutations int           `json:"permutations"`
+	ZeroBits     int           `json:"zero_bits"`
+	Elapsed      time.Duration `json:"elapsed_ns"`
 }
 
-func HighwayHash64(buf []byte, key [32]byte) []byte {
-	h, _ := highwayhash.New64(key[:])
-	h.Reset()
-	h.Write(buf[:])
-	sum := h.Sum(nil)
-	return sum
+// Reporter writes results as soon as they are available, so that a long
+// run that is interrupted still leaves the finished sizes behind
+type Reporter interface {
+	Report(r Result) error
+	Close() error
 }
 
-func mask(b, m int) byte {
-	s := uint(9 - m)
-	return byte(((1 << s) - 1) << uint(b%m))
+// NewReporter returns a reporter for format "text", "csv" or "json"
+func NewReporter(w io.Writer, format string) (Reporter, error) {
+	switch format {
+	case "text":
+		return &textReporter{out: w}, nil
+	case "csv":
+		c := csv.NewWriter(w)
+		if err := c.Write([]string{"algorithm", "size", "permutations", "zero_bits", "elapsed_ns"}); err != nil {
+			return nil, err
+		}
+		return &csvReporter{w: c}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want text, csv or json", format)
 }
 
-// Slice of sorted bytes
-type SortBytes [][]byte
+// textReporter prints a line per result and a table per algorithm
+type textReporter struct {
+	out     io.Writer
+	algo    string
+	results []Result
+}
 
-func (s SortBytes) Less(i, j int) bool {
-	for index := range s[i] {
-		if s[i][index] != s[j][index] {
-			return s[i][index] < s[j][index]
+func (t *textReporter) Report(r Result) error {
+	if r.Algorithm != t.algo {
+		if err := t.flush(); err != nil {
+			return err
 		}
+		t.algo = r.Algorithm
 	}
-	return false
+	t.results = append(t.results, r)
+
+	_, err := fmt.Fprintf(t.out, "Permutations: %d -- zero bits: %d -- duration: %v (%s)\n", r.Permutations, r.ZeroBits, r.Elapsed, r.Algorithm)
+	return err
 }
 
-func (s SortBytes) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+func (t *textReporter) flush() error {
+	if len(t.results) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(t.out)
+	fmt.Fprintln(t.out, t.algo)
+	w := tabwriter.NewWriter(t.out, 0, 0, 3, '-', tabwriter.AlignRight|tabwriter.Debug)
+	fmt.Fprintln(w, "Permutations", "\t", "Zero bits", "\t", "Duration")
+	for _, r := range t.results {
+		fmt.Fprintln(w, r.Permutations, "\t", r.ZeroBits, "\t", r.Elapsed)
+	}
+	t.results = t.results[:0]
+	return w.Flush()
 }
 
-func (s SortBytes) Len() int {
-	return len(s)
+func (t *textReporter) Close() error {
+	return t.flush()
 }
 
-func TestHashPermutationsRange(msg []byte, key [32]byte, cpu int, cpuShift uint, algo string, results chan<- SortBytes) {
+// csvReporter writes one row per result
+type csvReporter struct {
+	w *csv.Writer
+}
 
-	var keys SortBytes
+func (c *csvReporter) Report(r Result) error {
+	c.w.Write([]string{
+		r.Algorithm,
+		strconv.FormatUint(uint64(r.Size), 10),
+		strconv.Itoa(r.Permutations),
+		strconv.Itoa(r.ZeroBits),
+		strconv.FormatInt(int64(r.Elapsed), 10),
+	})
+	c.w.Flush()
+	return c.w.Error()
+}
 
-	for m := 8; m >= 1; m-- {
+func (c *csvReporter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
 
-		bStart := (len(msg) * m * cpu) >> cpuShift
-		bEnd := (len(msg) * m * (cpu + 1)) >> cpuShift
+// jsonReporter writes one JSON object per line
+type jsonReporter struct {
+	enc *json.Encoder
+}
 
-		for b := bStart; b < bEnd; b++ {
-			// Change message with mask
-			msg[b/m] = msg[b/m] ^ mask(b, m)
-
-			var tag []byte
-			switch algo {
-			case "poly1305":
-				tag = Poly1305Sum(msg, key)
-			case "blake2b":
-				tag = Blake2bSum(msg)
-			case "blake2b-256":
-				tag = Blake2b256Sum(msg)
-			case "siphash":
-				tag = SipHashSum(msg, key)
-			case "highwayhash256":
-				tag = HighwayHash(msg, key)
-			case "highwayhash128":
-				tag = HighwayHash128(msg, key)
-			case "highwayhash64":
-				tag = HighwayHash64(msg, key)
-			}
+func (j *jsonReporter) Report(r Result) error {
+	return j.enc.Encode(r)
+}
 
-			keys = append(keys, tag)
+func (j *jsonReporter) Close() error {
+	return nil
+}
 
-			// Undo change
-			msg[b/m] = msg[b/m] ^ mask(b, m)
+// parseKey decodes a 32 byte hex key, "random" generates a new one and an
+// empty string returns the fixed key 0xff, 0xfe, ... used by earlier runs
+func parseKey(s string) (key [32]byte, err error) {
+	switch s {
+	case "":
+		for i := range key {
+			key[i] = byte(255 - i)
 		}
+		return key, nil
+	case "random":
+		_, err = rand.Read(key[:])
+		return key, err
 	}
 
-	// sanity check if message not corrupted
-	for i := range msg {
-		if msg[i] != byte(i) {
-			panic("memory corrupted")
-		}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return key, fmt.Errorf("invalid key: %v", err)
+	}
+	if len(b) != len(key) {
+		return key, fmt.Errorf("invalid key: got %d bytes, want %d", len(b), len(key))
 	}
+	copy(key[:], b)
+	return key, nil
+}
 
-	sort.Sort(keys)
+// parseAlgorithms splits a comma separated list, "all" selects every registered algorithm
+func parseAlgorithms(s string) ([]Algorithm, error) {
+	if s == "all" {
+		return Algorithms(), nil
+	}
 
-	results <- keys
+	var algos []Algorithm
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown algorithm %q, want one of %s", name, strings.Join(names(), ", "))
+		}
+		algos = append(algos, a)
+	}
+	if len(algos) == 0 {
+		return nil, errors.New("no algorithm selected")
+	}
+	return algos, nil
 }
 
-func TestHashPermutations(key [32]byte, msgSize uint, algo string) (permutations, zeroBits int, elapsed time.Duration) {
-	start := time.Now()
-	fmt.Println("Starting", start.Format("3:04PM"), "...")
+// parseSize parses a byte count with an optional K, M or G suffix (powers of 1024)
+func parseSize(s string) (uint, error) {
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
 
-	msg := make([]byte, msgSize)
-	for i := range msg {
-		msg[i] = byte(i)
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
 	}
+	return uint(n) << shift, nil
+}
 
-	cpus := runtime.NumCPU()
-	// make sure nr of CPUs is a power of 2
-	cpuShift := uint(len(strconv.FormatInt(int64(cpus), 2)) - 1)
-	cpus = 1 << cpuShift
+// sizes returns lo, 2*lo, 4*lo, ... up to and including hi
+func sizes(lo, hi uint) []uint {
+	var all []uint
+	for size := lo; size <= hi && size >= lo; size *= 2 {
+		all = append(all, size)
+	}
+	return all
+}
 
-	var wg sync.WaitGroup
-	results := make(chan SortBytes)
+// names returns the names of all registered algorithms
+func names() []string {
+	var all []string
+	for _, a := range Algorithms() {
+		all = append(all, a.Name)
+	}
+	return all
+}
 
-	for cpu := 0; cpu < cpus; cpu++ {
+func main() {
+	var (
+		mode    = flag.String("mode", "permutations", "permutations, quality for the avalanche, bit independence and chi-square tests, or collisions of truncated outputs")
+		algos   = flag.String("algos", defaultAlgorithms, "comma separated algorithms: "+strings.Join(names(), ", ")+" or all")
+		minSize = flag.String("min", "256", "smallest message size in bytes, K/M/G suffixes allowed")
+		maxSize = flag.String("max", "4M", "largest message size in bytes, sizes double from min up to max")
+		key     = flag.String("key", "", "32 byte key in hex, or random; defaults to the fixed key 0xff, 0xfe, ...")
+		workers = flag.Int("workers", runtime.NumCPU(), "worker goroutines")
+		format  = flag.String("format", "text", "output format: text, csv or json (one object per line)")
+		output  = flag.String("o", "", "output file, defaults to stdout")
+		memory  = flag.String("mem", "1G", "memory for sorting tags, K/M/G suffixes allowed; more tags are spilled to temporary files")
+		tmp     = flag.String("tmp", "", "directory for temporary files, defaults to the system temporary directory")
+
+		quality    = DefaultQuality
+		collisions = DefaultCollisions
+		words      = flag.String("words", "/usr/share/dict/words", "collisions: word list for the words inputs, one word per line")
+	)
+	flag.IntVar(&quality.Samples, "samples", quality.Samples, "quality: random inputs for the avalanche and bit independence tests")
+	flag.IntVar(&quality.InputSize, "input", quality.InputSize, "quality and collisions: input size in bytes")
+	flag.IntVar(&quality.Buckets, "buckets", quality.Buckets, "quality: chi-square buckets, filled from 100 sequential inputs per bucket")
+	flag.Float64Var(&quality.Alpha, "alpha", quality.Alpha, "quality and collisions: significance level of each test")
+	flag.Int64Var(&quality.Seed, "seed", quality.Seed, "quality: seed of the random inputs")
+	flag.IntVar(&collisions.Bits, "bits", collisions.Bits, "collisions: output bits kept after truncation, at most 64")
+	flag.StringVar(&collisions.Inputs, "inputs", collisions.Inputs, "collisions: flips (sparse inputs with few bits set), counter or words")
+	flag.Int64Var(&collisions.Limit, "limit", collisions.Limit, "collisions: inputs to hash, 12 bytes each sorted within -mem; 0 expects about 8 collisions")
+	flag.IntVar(&collisions.Pairs, "pairs", collisions.Pairs, "collisions: colliding pairs to report")
+	flag.Parse()
+
+	// every registered algorithm is tested unless -algos is given
+	all := "all"
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "algos" {
+			all = *algos
+		}
+	})
 
-		wg.Add(1)
-		go func(cpu int) {
-			msgCopy := make([]byte, len(msg))
-			copy(msgCopy, msg)
-			defer wg.Done()
-			TestHashPermutationsRange(msgCopy, key, cpu, cpuShift, algo, results)
-		}(cpu)
+	mem, err := parseSize(*memory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	spill := Spill{Memory: mem, Dir: *tmp}
 
-	// Wait for workers to complete
-	go func() {
-		wg.Wait()
-		close(results) // Close output channel
-	}()
+	switch *mode {
+	case "quality":
+		if err := runQuality(all, *key, *workers, quality, *format, *output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "collisions":
+		collisions.InputSize = quality.InputSize
+		collisions.Alpha = quality.Alpha
+		collisions.Spill = spill
+		if collisions.Inputs == "words" {
+			list, err := LoadWords(*words)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			collisions.Words = list
+		}
+		if err := runCollisions(all, *key, *workers, collisions, *format, *output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "permutations":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mode %q, want permutations, quality or collisions\n", *mode)
+		os.Exit(1)
+	}
 
-	keys := make([]SortBytes, 0, cpus)
+	if err := run(*algos, *minSize, *maxSize, *key, *workers, spill, *format, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	// Collect sub sorts
-	for r := range results {
-		keys = append(keys, r)
+func run(algos, minSize, maxSize, key string, workers int, spill Spill, format, output string) error {
+	selected, err := parseAlgorithms(algos)
+	if err != nil {
+		return err
+	}
+	lo, err := parseSize(minSize)
+	if err != nil {
+		return err
+	}
+	hi, err := parseSize(maxSize)
+	if err != nil {
+		return err
+	}
+	if lo > hi {
+		return fmt.Errorf("min size %d is larger than max size %d", lo, hi)
+	}
+	k, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if workers < 1 {
+		return fmt.Errorf("invalid worker count %d", workers)
 	}
 
-	var tagprev *big.Int
-	var smallest *big.Int
+	out, err := create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-	h := &HexHeap{}
-	heap.Init(h)
+	reporter, err := NewReporter(out, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "key %x\n", k)
 
-	// Push initial keys onto heap
-	for stack, sb := range keys {
-		if len(sb) > 0 {
-			heap.Push(h, Hex{hex.EncodeToString(sb[0]), stack, 1})
+	for _, algo := range selected {
+		for _, size := range sizes(lo, hi) {
+			permutations, zeroBits, elapsed, err := TestHashPermutations(k, size, algo, workers, spill)
+			if err != nil {
+				return err
+			}
+			err = reporter.Report(Result{
+				Algorithm:    algo.Name,
+				Size:         size,
+				Permutations: permutations,
+				ZeroBits:     zeroBits,
+				Elapsed:      elapsed,
+			})
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return reporter.Close()
+}
 
-	// Iterate over heap
-	for h.Len() > 0 {
-		hi := heap.Pop(h).(Hex)
-
-		tag := new(big.Int)
-		tag.SetString(hi.hex, 16)
+// runQuality runs the statistical tests for each selected algorithm and exits
+// with an error when any of them fails
+func runQuality(algos, key string, workers int, q Quality, format, output string) error {
+	selected, err := parseAlgorithms(algos)
+	if err != nil {
+		return err
+	}
+	k, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if q.Samples < 1 || q.InputSize < 1 || q.Buckets < 2 || q.Alpha <= 0 || q.Alpha >= 1 {
+		return fmt.Errorf("invalid quality parameters %+v", q)
+	}
 
-		if tagprev != nil {
-			diff := new(big.Int)
-			diff.Sub(tag, tagprev)
-			//fmt.Println(k, diff)
+	out, err := create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-			if smallest == nil {
-				smallest = new(big.Int)
-				*smallest = *diff
-			} else if smallest.Cmp(diff) > 0 {
-				*smallest = *diff
-			}
+	reporter, err := NewQualityReporter(out, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "key %x\n", k)
+
+	var failed []string
+	for _, algo := range selected {
+		fmt.Fprintln(os.Stderr, "Testing", algo.Name, "...")
+		report, err := TestHashQuality(algo, k, workers, q)
+		if err != nil {
+			return err
 		}
-
-		tagprev = tag
-
-		if hi.index < len(keys[hi.stack]) { // Push new entry when still available
-			heap.Push(h, Hex{hex.EncodeToString(keys[hi.stack][hi.index]), hi.stack, hi.index + 1})
+		if !report.Pass {
+			failed = append(failed, algo.Name)
+		}
+		if err := reporter.Report(report); err != nil {
+			return err
 		}
 	}
+	if err := reporter.Close(); err != nil {
+		return err
+	}
 
-	elapsed = time.Since(start)
-	fmt.Println(smallest)
-	return len(keys) * len(keys[0]), 8*len(keys[0][0]) - len(smallest.Text(2)), elapsed
-}
-
-// An HexHeap is a min-heap of hexadecimals.
-type HexHeap []Hex
-
-type Hex struct {
-	hex   string
-	stack int
-	index int
+	if len(failed) > 0 {
+		return fmt.Errorf("failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-func (h HexHeap) Len() int           { return len(h) }
-func (h HexHeap) Less(i, j int) bool { return h[i].hex < h[j].hex }
-func (h HexHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+// runCollisions searches for collisions of the truncated outputs of each
+// selected algorithm and exits with an error when any of them collides more
+// often than a random function would. When every algorithm is selected, those
+// with outputs shorter than the truncation are skipped.
+func runCollisions(algos, key string, workers int, c Collisions, format, output string) error {
+	selected, err := parseAlgorithms(algos)
+	if err != nil {
+		return err
+	}
+	k, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if c.Bits < 1 || c.Bits > 64 || c.InputSize < 1 || c.Limit < 0 || c.Pairs < 0 || c.Alpha <= 0 || c.Alpha >= 1 {
+		return fmt.Errorf("invalid collision parameters bits=%d input=%d limit=%d pairs=%d alpha=%g",
+			c.Bits, c.InputSize, c.Limit, c.Pairs, c.Alpha)
+	}
 
-func (h *HexHeap) Push(x interface{}) {
-	*h = append(*h, x.(Hex))
-}
+	out, err := create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-func (h *HexHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[0 : n-1]
-	return x
-}
+	reporter, err := NewCollisionReporter(out, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "key %x\n", k)
 
-func permuteAlgorithm(algo string) {
-	rand.Seed(time.Now().UTC().UnixNano())
+	var failed []string
+	for _, algo := range selected {
+		if algos == "all" && algo.Size*8 < c.Bits {
+			fmt.Fprintln(os.Stderr, "Skipping", algo.Name, "with", algo.Size*8, "bit output")
+			continue
+		}
 
-	var key [32]byte
-	for i := range key {
-		key[i] = byte(255 - i)
+		fmt.Fprintln(os.Stderr, "Searching", algo.Name, "...")
+		report, err := TestHashCollisions(algo, k, workers, c)
+		if err != nil {
+			return err
+		}
+		if !report.Pass {
+			failed = append(failed, algo.Name)
+		}
+		if err := reporter.Report(report); err != nil {
+			return err
+		}
+	}
+	if err := reporter.Close(); err != nil {
+		return err
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, '-', tabwriter.AlignRight|tabwriter.Debug)
-	fmt.Fprintln(w, "Permutations", "\t", "Zero bits", "\t", "Duration")
+	if len(failed) > 0 {
+		return fmt.Errorf("failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
 
-	const hundredFiftyMillionPermutations = 23
-	for shift := uint(8); shift < hundredFiftyMillionPermutations; shift++ {
-		permutations, zeroBits, elapsed := TestHashPermutations(key, 1<<shift, algo)
-		fmt.Printf("Permutations: %d -- zero bits: %d -- duration: %v (%s)\n", permutations, zeroBits, elapsed, algo)
-		fmt.Fprintln(w, permutations, "\t", zeroBits, "\t", elapsed)
+// create opens the output file, an empty name writes to stdout
+func create(output string) (io.WriteCloser, error) {
+	if output == "" {
+		return nopCloser{os.Stdout}, nil
 	}
-	fmt.Println()
-	fmt.Println(algo)
-	w.Flush()
+	return os.Create(output)
 }
 
-func main() {
-	permuteAlgorithm("blake2b")
-	permuteAlgorithm("blake2b-256")
-	permuteAlgorithm("poly1305")
-	permuteAlgorithm("siphash")
-	permuteAlgorithm("highwayhash256")
-	permuteAlgorithm("highwayhash128")
-	permuteAlgorithm("highwayhash64")
+type nopCloser struct {
+	io.Writer
 }
+
+func (nopCloser) Close() error { return nil }
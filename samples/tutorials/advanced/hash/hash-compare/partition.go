@@ -0,0 +1,86 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/09        Feng Yifei
+ */
+
+package main
+
+import "sync/atomic"
+
+// defaultChunk is the number of modifications taken at a time: large enough to
+// keep atomic operations cheap, small enough to balance the workers.
+const defaultChunk = 1 << 12
+
+// flipSpace lays out all single byte modifications of the permutation test as one
+// sequence: m counts down from 8 to 1 and, for each m, b counts up from 0 to
+// size*m. Workers take chunks of the sequence, so their number need not be a
+// power of two and workers that finish early take the remaining chunks. Every
+// modification is processed exactly once, giving the same set of tags as the
+// power of two split.
+type flipSpace struct {
+	next  int64 // next position to take, atomic; first field for 8 byte alignment on 32 bit platforms
+	size  int   // message length
+	total int64 // number of modifications, size*36
+	chunk int64
+}
+
+func newFlipSpace(size, chunk int) *flipSpace {
+	if chunk < 1 {
+		chunk = 1
+	}
+	return &flipSpace{size: size, total: int64(size) * 36, chunk: int64(chunk)}
+}
+
+// take returns the next chunk [start, end) of the sequence, or false when none is left.
+func (f *flipSpace) take() (start, end int64, ok bool) {
+	start = atomic.AddInt64(&f.next, f.chunk) - f.chunk
+	if start >= f.total {
+		return 0, 0, false
+	}
+	end = start + f.chunk
+	if end > f.total {
+		end = f.total
+	}
+	return start, end, true
+}
+
+// abort gives up the remaining chunks; take returns false from then on.
+func (f *flipSpace) abort() {
+	atomic.StoreInt64(&f.next, f.total)
+}
+
+// at returns m and b of the g-th modification of the sequence.
+func (f *flipSpace) at(g int64) (m, b int) {
+	for m = 8; m > 1; m-- {
+		n := int64(f.size * m)
+		if g < n {
+			break
+		}
+		g -= n
+	}
+	return m, int(g)
+}
@@ -0,0 +1,106 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/09        Feng Yifei
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestFlipSpace(t *testing.T) {
+	const size = 5
+
+	space := newFlipSpace(size, 7)
+	seen := make(map[[2]int]int)
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for w := 0; w < 3; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start, end, ok := space.take()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				for g := start; g < end; g++ {
+					m, b := space.at(g)
+					seen[[2]int{m, b}]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// As with the power of two split, b covers [0, size*m) exactly once for each m.
+	if len(seen) != size*36 {
+		t.Fatalf("%d flips, want %d", len(seen), size*36)
+	}
+	for m := 8; m >= 1; m-- {
+		for b := 0; b < size*m; b++ {
+			if n := seen[[2]int{m, b}]; n != 1 {
+				t.Fatalf("flip m=%d b=%d taken %d times", m, b, n)
+			}
+		}
+	}
+}
+
+func TestPermutationsAnyWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash-compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	algo, _ := Lookup("siphash")
+	key, _ := parseKey("")
+	spill := Spill{Memory: 4 << 10, Dir: dir}
+
+	permutations, zeroBits, _, err := TestHashPermutations(key, 64, algo, 4, spill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 3, 5} {
+		p, z, _, err := TestHashPermutations(key, 64, algo, workers, spill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != permutations || z != zeroBits {
+			t.Fatalf("%d workers: %d permutations, %d zero bits, want %d, %d", workers, p, z, permutations, zeroBits)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/08        Feng Yifei
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+)
+
+// Quality holds the parameters of the statistical quality tests.
+type Quality struct {
+	// Samples is the number of random inputs for the avalanche and bit independence tests.
+	Samples int
+	// InputSize is the input length in bytes.
+	InputSize int
+	// Buckets is the number of chi-square buckets, filled from Buckets*100 sequential counters.
+	Buckets int
+	// Alpha is the significance level, Bonferroni corrected when several statistics are tested together.
+	Alpha float64
+	// Seed seeds the random inputs; the same seed gives the same results.
+	Seed int64
+}
+
+// DefaultQuality uses 32 byte inputs, close to the usual length of a shard key.
+var DefaultQuality = Quality{Samples: 1000, InputSize: 32, Buckets: 1024, Alpha: 0.001, Seed: 1}
+
+// bicBits limits the bit independence test to the first bicBits output bits, since
+// the number of pairs grows with the square of the bit count.
+const bicBits = 64
+
+// Check is the result of one statistical test.
+type Check struct {
+	// Test is sac, bic or chi-square.
+	Test string `json:"test"`
+	// Value is the largest deviation for sac and bic, lower is better, and the
+	// p-value for chi-square, higher is better.
+	Value float64 `json:"value"`
+	// Threshold to pass: sac and bic need Value <= Threshold, chi-square needs
+	// Value >= Threshold.
+	Threshold float64 `json:"threshold"`
+	Pass      bool    `json:"pass"`
+	// Detail explains the result, e.g. where the largest deviation is.
+	Detail string `json:"detail"`
+}
+
+// QualityReport holds all statistical test results of one algorithm.
+type QualityReport struct {
+	Algorithm string  `json:"algorithm"`
+	Checks    []Check `json:"checks"`
+	Pass      bool    `json:"pass"`
+}
+
+// TestHashQuality runs the strict avalanche criterion (SAC), bit independence
+// criterion (BIC) and chi-square uniformity tests on algo.
+func TestHashQuality(algo Algorithm, key [32]byte, workers int, q Quality) (QualityReport, error) {
+	report := QualityReport{Algorithm: algo.Name, Pass: true}
+
+	sac, bic, err := avalanche(algo, key[:algo.KeySize], workers, q)
+	if err != nil {
+		return report, err
+	}
+	chi, err := chiSquare(algo, key[:algo.KeySize], q)
+	if err != nil {
+		return report, err
+	}
+
+	report.Checks = []Check{sac, bic, chi}
+	for _, c := range report.Checks {
+		report.Pass = report.Pass && c.Pass
+	}
+	return report, nil
+}
+
+// avalanche counts how often each output bit flips when each input bit is
+// flipped (SAC), and how often pairs of the first bicBits output bits flip
+// together (BIC).
+func avalanche(algo Algorithm, key []byte, workers int, q Quality) (sac, bic Check, err error) {
+	var (
+		inBits   = q.InputSize * 8
+		outBits  = algo.Size * 8
+		pairBits = outBits
+	)
+	if pairBits > bicBits {
+		pairBits = bicBits
+	}
+
+	type counts struct {
+		flips []uint32 // [inBits][outBits]
+		ones  []uint64 // [pairBits]
+		both  []uint64 // [pairBits][pairBits], only [j][k] with j < k is used
+		err   error
+	}
+
+	if workers > q.Samples {
+		workers = q.Samples
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	partial := make([]counts, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			c := counts{
+				flips: make([]uint32, inBits*outBits),
+				ones:  make([]uint64, pairBits),
+				both:  make([]uint64, pairBits*pairBits),
+			}
+			defer func() { partial[w] = c }()
+
+			rng := rand.New(rand.NewSource(q.Seed + int64(w)))
+			msg := make([]byte, q.InputSize)
+			diff := make([]byte, algo.Size)
+
+			for s := w; s < q.Samples; s += workers {
+				rng.Read(msg)
+				base, err := algo.Sum(msg, key)
+				if err != nil {
+					c.err = err
+					return
+				}
+
+				for i := 0; i < inBits; i++ {
+					msg[i/8] ^= 1 << uint(i%8)
+					out, err := algo.Sum(msg, key)
+					msg[i/8] ^= 1 << uint(i%8)
+					if err != nil {
+						c.err = err
+						return
+					}
+
+					row := c.flips[i*outBits : (i+1)*outBits]
+					for j := range diff {
+						diff[j] = base[j] ^ out[j]
+						for d := diff[j]; d != 0; d &= d - 1 {
+							row[j*8+bits.LeadingZeros8(d&-d)]++
+						}
+					}
+
+					v := prefix(diff) >> uint(64-pairBits)
+					for x := v; x != 0; x &= x - 1 {
+						j := pairBits - 1 - bits.TrailingZeros64(x)
+						c.ones[j]++
+						for y := x & (x - 1); y != 0; y &= y - 1 {
+							k := pairBits - 1 - bits.TrailingZeros64(y)
+							c.both[k*pairBits+j]++
+						}
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := partial[0]
+	for _, c := range partial {
+		if c.err != nil {
+			return sac, bic, c.err
+		}
+	}
+	for _, c := range partial[1:] {
+		for i, v := range c.flips {
+			total.flips[i] += v
+		}
+		for i, v := range c.ones {
+			total.ones[i] += v
+		}
+		for i, v := range c.both {
+			total.both[i] += v
+		}
+	}
+
+	// SAC: each output bit should flip with probability 1/2; the deviation is
+	// normally distributed with standard deviation 1/(2*sqrt(n)).
+	n := float64(q.Samples)
+	worst, at := 0.0, 0
+	for i, v := range total.flips {
+		if dev := math.Abs(float64(v)/n - 0.5); dev > worst {
+			worst, at = dev, i
+		}
+	}
+	cells := inBits * outBits
+	sac = Check{
+		Test:      "sac",
+		Value:     worst,
+		Threshold: zScore(q.Alpha, cells) / (2 * math.Sqrt(n)),
+		Detail:    fmt.Sprintf("input bit %d -> output bit %d flips with p=%.4f", at/outBits, at%outBits, float64(total.flips[at])/n),
+	}
+	sac.Pass = sac.Value <= sac.Threshold
+
+	// BIC: flips of any two output bits should be uncorrelated; the correlation
+	// has a standard deviation of about 1/sqrt(m).
+	m := n * float64(inBits)
+	worst, wj, wk := 0.0, 0, 0
+	for j := 0; j < pairBits; j++ {
+		for k := j + 1; k < pairBits; k++ {
+			pj := float64(total.ones[j]) / m
+			pk := float64(total.ones[k]) / m
+			pjk := float64(total.both[j*pairBits+k]) / m
+
+			corr := 1.0 // bits that always or never flip count as fully correlated
+			if den := math.Sqrt(pj * (1 - pj) * pk * (1 - pk)); den > 0 {
+				corr = math.Abs(pjk-pj*pk) / den
+			}
+			if corr > worst {
+				worst, wj, wk = corr, j, k
+			}
+		}
+	}
+	pairs := pairBits * (pairBits - 1) / 2
+	bic = Check{
+		Test:      "bic",
+		Value:     worst,
+		Threshold: zScore(q.Alpha, pairs) / math.Sqrt(m),
+		Detail:    fmt.Sprintf("output bits %d and %d, first %d output bits checked", wj, wk, pairBits),
+	}
+	bic.Pass = bic.Value <= bic.Threshold
+	return sac, bic, nil
+}
+
+// chiSquare hashes Buckets*100 sequential counters into buckets and tests
+// whether they are uniform.
+func chiSquare(algo Algorithm, key []byte, q Quality) (Check, error) {
+	var (
+		buckets = make([]int, q.Buckets)
+		inputs  = q.Buckets * 100
+		msg     = make([]byte, q.InputSize)
+	)
+	for i := 0; i < inputs; i++ {
+		counter(msg, uint64(i))
+		tag, err := algo.Sum(msg, key)
+		if err != nil {
+			return Check{}, err
+		}
+		buckets[bucket(tag, q.Buckets)]++
+	}
+
+	expected := float64(inputs) / float64(q.Buckets)
+	stat := 0.0
+	for _, o := range buckets {
+		d := float64(o) - expected
+		stat += d * d / expected
+	}
+
+	df := q.Buckets - 1
+	c := Check{
+		Test:      "chi-square",
+		Value:     chiSquareP(stat, df),
+		Threshold: q.Alpha,
+		Detail:    fmt.Sprintf("chi2=%.1f df=%d, %d sequential inputs", stat, df, inputs),
+	}
+	c.Pass = c.Value >= c.Threshold
+	return c, nil
+}
+
+// counter writes i big-endian to the end of msg and zeroes the rest. Inputs
+// shorter than 8 bytes keep only the low bytes.
+func counter(msg []byte, i uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], i)
+
+	for j := range msg {
+		msg[j] = 0
+	}
+	if len(msg) >= len(buf) {
+		copy(msg[len(msg)-len(buf):], buf[:])
+	} else {
+		copy(msg, buf[len(buf)-len(msg):])
+	}
+}
+
+// prefix returns the first 8 bytes of tag as a big-endian integer, padding
+// shorter tags with zero low bytes.
+func prefix(tag []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], tag)
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// bucket maps tag to one of n buckets by its high bits: the first 8 bytes as a
+// fraction of 2^64, scaled by n. Taking prefix(tag) modulo n would use the low
+// bits, which are the zero padding of tags shorter than 8 bytes.
+func bucket(tag []byte, n int) int {
+	hi, _ := bits.Mul64(prefix(tag), uint64(n))
+	return int(hi)
+}
+
+// zScore returns the two-sided critical value of one statistic when tests
+// statistics are tested together at an overall significance level alpha.
+func zScore(alpha float64, tests int) float64 {
+	return math.Sqrt2 * math.Erfinv(1-alpha/float64(tests))
+}
+
+// chiSquareP returns the upper tail probability of the chi-square distribution
+// with df degrees of freedom, using the Wilson-Hilferty approximation.
+func chiSquareP(stat float64, df int) float64 {
+	k := float64(df)
+	z := (math.Cbrt(stat/k) - (1 - 2/(9*k))) / math.Sqrt(2/(9*k))
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// QualityReporter writes the results of the statistical quality tests.
+type QualityReporter interface {
+	Report(r QualityReport) error
+	Close() error
+}
+
+// NewQualityReporter returns a QualityReporter for the "text", "csv" or "json" format.
+func NewQualityReporter(w io.Writer, format string) (QualityReporter, error) {
+	switch format {
+	case "text":
+		return &textQuality{out: w}, nil
+	case "csv":
+		c := csv.NewWriter(w)
+		if err := c.Write([]string{"algorithm", "test", "value", "threshold", "pass", "detail"}); err != nil {
+			return nil, err
+		}
+		return &csvQuality{w: c}, nil
+	case "json":
+		return &jsonQuality{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want text, csv or json", format)
+}
+
+// textQuality writes a table per algorithm.
+type textQuality struct {
+	out io.Writer
+}
+
+func (t *textQuality) Report(r QualityReport) error {
+	verdict := "PASS"
+	if !r.Pass {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(t.out, "\n%s: %s\n", r.Algorithm, verdict)
+
+	w := tabwriter.NewWriter(t.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "test\tvalue\tthreshold\tresult\tdetail")
+	for _, c := range r.Checks {
+		result := "pass"
+		if !c.Pass {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%.6f\t%.6f\t%s\t%s\n", c.Test, c.Value, c.Threshold, result, c.Detail)
+	}
+	return w.Flush()
+}
+
+func (t *textQuality) Close() error {
+	return nil
+}
+
+// csvQuality writes a row per test.
+type csvQuality struct {
+	w *csv.Writer
+}
+
+func (c *csvQuality) Report(r QualityReport) error {
+	for _, check := range r.Checks {
+		c.w.Write([]string{
+			r.Algorithm,
+			check.Test,
+			strconv.FormatFloat(check.Value, 'g', -1, 64),
+			strconv.FormatFloat(check.Threshold, 'g', -1, 64),
+			strconv.FormatBool(check.Pass),
+			check.Detail,
+		})
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvQuality) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonQuality writes a JSON line per algorithm.
+type jsonQuality struct {
+	enc *json.Encoder
+}
+
+func (j *jsonQuality) Report(r QualityReport) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonQuality) Close() error {
+	return nil
+}
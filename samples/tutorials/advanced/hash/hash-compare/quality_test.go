@@ -0,0 +1,115 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/08        Feng Yifei
+ */
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuality(t *testing.T) {
+	q := Quality{Samples: 200, InputSize: 16, Buckets: 64, Alpha: 0.001, Seed: 1}
+
+	var key [32]byte
+	for _, c := range []struct {
+		algo string
+		pass map[string]bool
+	}{
+		{"sha256", map[string]bool{"sac": true, "bic": true, "chi-square": true}},
+		// CRC is linear: flipping an input bit always flips the same output bits,
+		// but sequential counters land evenly in every bucket.
+		{"crc32", map[string]bool{"sac": false, "bic": false, "chi-square": true}},
+	} {
+		algo, _ := Lookup(c.algo)
+		report, err := TestHashQuality(algo, key, 3, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Checks) != len(c.pass) {
+			t.Fatalf("%s: %d checks, want %d", c.algo, len(report.Checks), len(c.pass))
+		}
+		for _, check := range report.Checks {
+			if check.Pass != c.pass[check.Test] {
+				t.Errorf("%s: %s = %+v, want pass=%v", c.algo, check.Test, check, c.pass[check.Test])
+			}
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	// Short tags spread over all buckets instead of collapsing into bucket 0.
+	const n = 64
+	for _, name := range []string{"fnv32a", "crc32"} {
+		algo, _ := Lookup(name)
+
+		used := make(map[int]bool)
+		msg := make([]byte, 16)
+		for i := 0; i < n*100; i++ {
+			counter(msg, uint64(i))
+			tag, err := algo.Sum(msg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := bucket(tag, n)
+			if b < 0 || b >= n {
+				t.Fatalf("%s: bucket %d out of range", name, b)
+			}
+			used[b] = true
+		}
+		if len(used) != n {
+			t.Errorf("%s: %d of %d buckets used", name, len(used), n)
+		}
+	}
+
+	for _, c := range []struct {
+		tag  []byte
+		n    int
+		want int
+	}{
+		{[]byte{0x00, 0, 0, 0}, 1024, 0},
+		{[]byte{0x80, 0, 0, 0}, 1024, 512},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, 1024, 1023},
+		{[]byte{0x80, 0, 0, 0, 0, 0, 0, 0, 0xff}, 3, 1},
+	} {
+		if got := bucket(c.tag, c.n); got != c.want {
+			t.Errorf("bucket(%x, %d) = %d, want %d", c.tag, c.n, got, c.want)
+		}
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	if z := zScore(0.05, 1); math.Abs(z-1.96) > 0.01 {
+		t.Errorf("zScore(0.05, 1) = %f, want 1.96", z)
+	}
+	// With 100 degrees of freedom, 124.3 has an upper tail probability of 0.05.
+	if p := chiSquareP(124.342, 100); math.Abs(p-0.05) > 0.002 {
+		t.Errorf("chiSquareP(124.342, 100) = %f, want 0.05", p)
+	}
+}
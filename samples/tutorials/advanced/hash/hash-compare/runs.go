@@ -0,0 +1,409 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/07        Feng Yifei
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+// Spill bounds the memory used by the permutation and collision tests. Tags are collected in an
+// in-memory buffer that is sorted and written to a temporary file when full; the
+// sorted files are then merged as a stream, so memory depends only on Memory and
+// not on the number of tags.
+type Spill struct {
+	// Memory is the total size in bytes of the sort buffers of all workers.
+	Memory uint
+	// Dir holds the temporary files; os.TempDir() is used when empty.
+	Dir string
+}
+
+// DefaultSpill uses 1GiB of memory.
+var DefaultSpill = Spill{Memory: 1 << 30}
+
+// maxFanIn bounds the number of spilled runs open at once while merging.
+const maxFanIn = 64
+
+// tagSorter collects fixed-size tags and spills a sorted run to a temporary file
+// whenever its buffer is full.
+type tagSorter struct {
+	size  int
+	limit int // number of tags the buffer holds
+	buf   []byte
+	dir   string
+	files []string
+	count int
+}
+
+// newTagSorter returns a tagSorter for size-byte tags with a memory-byte buffer.
+// The buffer holds at least one tag.
+func newTagSorter(size int, memory uint, dir string) *tagSorter {
+	limit := int(memory / uint(size))
+	if limit < 1 {
+		limit = 1
+	}
+	return &tagSorter{size: size, limit: limit, dir: dir}
+}
+
+// Add copies tag into the buffer, spilling the buffer first if it is full.
+func (s *tagSorter) Add(tag []byte) error {
+	if len(s.buf) == s.limit*s.size {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	if s.buf == nil {
+		s.buf = make([]byte, 0, s.limit*s.size)
+	}
+
+	s.buf = append(s.buf, tag[:s.size]...)
+	s.count++
+	return nil
+}
+
+// spill sorts the buffer and writes it to a new temporary file.
+func (s *tagSorter) spill() error {
+	sort.Sort(flatTags{buf: s.buf, size: s.size, tmp: make([]byte, s.size)})
+
+	file, err := ioutil.TempFile(s.dir, "hash-compare-run-")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, file.Name())
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	_, err = w.Write(s.buf)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	s.buf = s.buf[:0]
+	return err
+}
+
+// mergeRuns returns the sorted runs of all sorters with at most fanIn of them
+// backed by open files. While more runs are spilled, the oldest fanIn are merged
+// into a new run, so no pass holds more than fanIn+1 files open. All spilled
+// files move to sorters[0] and are removed by its Close.
+func mergeRuns(sorters []*tagSorter, fanIn int) ([]tagReader, error) {
+	if fanIn < 2 {
+		fanIn = 2
+	}
+
+	owner := sorters[0]
+	for _, s := range sorters[1:] {
+		owner.files = append(owner.files, s.files...)
+		s.files = nil
+	}
+
+	for len(owner.files) > fanIn {
+		merged, err := owner.mergeFiles(owner.files[:fanIn])
+		if err != nil {
+			return nil, err
+		}
+		owner.files = append(append([]string(nil), owner.files[fanIn:]...), merged)
+	}
+
+	var runs []tagReader
+	for _, name := range owner.files {
+		r, err := owner.openRun(name)
+		if err != nil {
+			closeAll(runs)
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	// Tags left in the buffers are sorted and returned as in-memory runs.
+	for _, s := range sorters {
+		if len(s.buf) > 0 {
+			sort.Sort(flatTags{buf: s.buf, size: s.size, tmp: make([]byte, s.size)})
+			runs = append(runs, &memRun{buf: s.buf, size: s.size})
+		}
+	}
+	return runs, nil
+}
+
+// openRun opens a spilled run for reading.
+func (s *tagSorter) openRun(name string) (*fileRun, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileRun{file: file, r: bufio.NewReaderSize(file, 1<<16), tag: make([]byte, s.size)}, nil
+}
+
+// mergeFiles merges the spilled runs in names into a new temporary file and
+// removes them once the merged run is written.
+func (s *tagSorter) mergeFiles(names []string) (string, error) {
+	var runs []tagReader
+	for _, name := range names {
+		r, err := s.openRun(name)
+		if err != nil {
+			closeAll(runs)
+			return "", err
+		}
+		runs = append(runs, r)
+	}
+
+	file, err := ioutil.TempFile(s.dir, "hash-compare-run-")
+	if err != nil {
+		closeAll(runs)
+		return "", err
+	}
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	err = mergeTags(runs, func(tag []byte) error {
+		_, err := w.Write(tag)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	for _, name := range names {
+		os.Remove(name)
+	}
+	return file.Name(), nil
+}
+
+// Close removes the temporary files and releases the buffer.
+func (s *tagSorter) Close() {
+	for _, name := range s.files {
+		os.Remove(name)
+	}
+	s.files = nil
+	s.buf = nil
+}
+
+// flatTags sorts fixed-size tags stored back to back in buf.
+type flatTags struct {
+	buf  []byte
+	size int
+	tmp  []byte
+}
+
+func (f flatTags) Len() int { return len(f.buf) / f.size }
+
+func (f flatTags) Less(i, j int) bool {
+	return bytes.Compare(f.at(i), f.at(j)) < 0
+}
+
+func (f flatTags) Swap(i, j int) {
+	copy(f.tmp, f.at(i))
+	copy(f.at(i), f.at(j))
+	copy(f.at(j), f.tmp)
+}
+
+func (f flatTags) at(i int) []byte {
+	return f.buf[i*f.size : (i+1)*f.size]
+}
+
+// tagReader reads the tags of a sorted run in order and returns io.EOF at the end.
+type tagReader interface {
+	// The slice returned by Next is valid until the next call.
+	Next() ([]byte, error)
+	Close() error
+}
+
+// memRun is a sorted run held in memory.
+type memRun struct {
+	buf  []byte
+	size int
+}
+
+func (r *memRun) Next() ([]byte, error) {
+	if len(r.buf) == 0 {
+		return nil, io.EOF
+	}
+	tag := r.buf[:r.size]
+	r.buf = r.buf[r.size:]
+	return tag, nil
+}
+
+func (r *memRun) Close() error {
+	r.buf = nil
+	return nil
+}
+
+// fileRun is a sorted run stored in a temporary file.
+type fileRun struct {
+	file *os.File
+	r    *bufio.Reader
+	tag  []byte
+}
+
+func (r *fileRun) Next() ([]byte, error) {
+	// A file that is not a whole number of tags ends with io.ErrUnexpectedEOF.
+	if _, err := io.ReadFull(r.r, r.tag); err != nil {
+		return nil, err
+	}
+	return r.tag, nil
+}
+
+func (r *fileRun) Close() error {
+	return r.file.Close()
+}
+
+func closeAll(runs []tagReader) {
+	for _, r := range runs {
+		r.Close()
+	}
+}
+
+// cursor is the current tag of a run being merged.
+type cursor struct {
+	tag []byte
+	run tagReader
+}
+
+// cursorHeap is a min-heap of cursors ordered by their current tag.
+type cursorHeap []*cursor
+
+func (h cursorHeap) Len() int            { return len(h) }
+func (h cursorHeap) Less(i, j int) bool  { return bytes.Compare(h[i].tag, h[j].tag) < 0 }
+func (h cursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x interface{}) { *h = append(*h, x.(*cursor)) }
+
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// mergeTags merges the sorted runs as a stream, calling fn with each tag in
+// order. The tag passed to fn is only valid during the call. All runs are closed
+// before it returns.
+func mergeTags(runs []tagReader, fn func(tag []byte) error) error {
+	defer closeAll(runs)
+
+	h := make(cursorHeap, 0, len(runs))
+	for _, r := range runs {
+		tag, err := r.Next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h = append(h, &cursor{tag: tag, run: r})
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		c := h[0]
+		if err := fn(c.tag); err != nil {
+			return err
+		}
+
+		tag, err := c.run.Next()
+		switch {
+		case err == io.EOF:
+			heap.Pop(&h)
+		case err != nil:
+			return err
+		default:
+			c.tag = tag
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// smallestGap merges the sorted runs as a stream and returns the number of tags
+// and the smallest big-endian difference between adjacent tags. gap is nil when
+// there are fewer than two tags. All runs are closed before it returns.
+func smallestGap(runs []tagReader, size int) (count int, gap []byte, err error) {
+	var (
+		prev = make([]byte, size)
+		diff = make([]byte, size)
+	)
+	err = mergeTags(runs, func(tag []byte) error {
+		if count > 0 {
+			subtract(diff, tag, prev)
+			if gap == nil {
+				gap = make([]byte, size)
+				copy(gap, diff)
+			} else if bytes.Compare(diff, gap) < 0 {
+				copy(gap, diff)
+			}
+		}
+		copy(prev, tag)
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, gap, nil
+}
+
+// subtract sets dst = a - b for equal-length big-endian unsigned integers with a >= b.
+func subtract(dst, a, b []byte) {
+	borrow := 0
+	for i := len(a) - 1; i >= 0; i-- {
+		d := int(a[i]) - int(b[i]) - borrow
+		borrow = 0
+		if d < 0 {
+			d += 256
+			borrow = 1
+		}
+		dst[i] = byte(d)
+	}
+}
+
+// leadingZeroBits returns the number of leading zero bits of gap. A zero gap counts
+// as one significant bit, matching the length of big.Int's binary text "0".
+func leadingZeroBits(gap []byte) int {
+	for i, b := range gap {
+		if b != 0 {
+			return 8*i + bits.LeadingZeros8(b)
+		}
+	}
+	return 8*len(gap) - 1
+}
@@ -0,0 +1,119 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/07        Feng Yifei
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestSmallestGap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash-compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const size = 4
+	rng := rand.New(rand.NewSource(1))
+
+	var all [][]byte
+	sorters := []*tagSorter{
+		newTagSorter(size, 10*size, dir), // spills several times
+		newTagSorter(size, 1<<20, dir),   // stays in memory
+	}
+	for _, s := range sorters {
+		defer s.Close()
+		for i := 0; i < 1000; i++ {
+			tag := make([]byte, size)
+			rng.Read(tag)
+			all = append(all, tag)
+			if err := s.Add(tag); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n, _ := ioutil.ReadDir(dir); len(n) != 99 {
+		t.Fatalf("%d runs spilled, want 99", len(n))
+	}
+
+	// 99 spilled runs merged 4 at a time leave at most 4 files to open
+	runs, err := mergeRuns(sorters, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := ioutil.ReadDir(dir); len(n) > 4 {
+		t.Fatalf("%d runs left after merging, want at most 4", len(n))
+	}
+
+	count, gap, err := smallestGap(runs, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return bytes.Compare(all[i], all[j]) < 0 })
+	want := make([]byte, size)
+	diff := make([]byte, size)
+	for i := 1; i < len(all); i++ {
+		subtract(diff, all[i], all[i-1])
+		if i == 1 || bytes.Compare(diff, want) < 0 {
+			copy(want, diff)
+		}
+	}
+	if count != len(all) || !bytes.Equal(gap, want) {
+		t.Fatalf("count = %d, gap = %x, want %d, %x", count, gap, len(all), want)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	for _, c := range []struct {
+		gap  []byte
+		want int
+	}{
+		{[]byte{0x80, 0}, 0},
+		{[]byte{0, 0x01}, 15},
+		{[]byte{0, 0x10}, 11},
+		{[]byte{0, 0}, 15},
+	} {
+		if got := leadingZeroBits(c.gap); got != c.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", c.gap, got, c.want)
+		}
+	}
+
+	diff := make([]byte, 2)
+	subtract(diff, []byte{0x01, 0x00}, []byte{0x00, 0xff})
+	if !bytes.Equal(diff, []byte{0, 1}) {
+		t.Fatalf("0x0100 - 0x00ff = %x", diff)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/11        Feng Yifei
+ */
+
+// Package frame 从 scanner.go 的粘包示例中抽象出通用的长度前缀帧：
+// 每帧由魔数、负载长度与负载组成，魔数、长度字段宽度与字节序均可配置。
+// 既可以作为 bufio.Scanner 的 split 函数使用，也提供基于 io.Reader 与 io.Writer 的 Reader、Writer。
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var (
+	// ErrMagic 帧头的魔数不匹配
+	ErrMagic = errors.New("frame: bad magic")
+	// ErrTooLarge 帧的负载长度超过 MaxSize 或长度字段能表示的范围
+	ErrTooLarge = errors.New("frame: frame too large")
+	// ErrTruncated 输入在一帧的中间结束
+	ErrTruncated = errors.New("frame: truncated frame")
+)
+
+// CorruptError 输入中无法解析的位置，Offset 为相对输入起点的字节偏移
+type CorruptError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+// Unwrap 返回 ErrMagic、ErrTooLarge 或 ErrTruncated，可以用 errors.Is 判断
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// Codec 帧格式
+type Codec struct {
+	// 帧头的魔数，可以为空
+	Magic []byte
+	// 长度字段的字节数：1、2、4 或 8，长度只计负载，不含帧头
+	LengthSize int
+	// 长度字段的字节序，为 nil 时使用大端序
+	Order binary.ByteOrder
+	// 负载的最大长度，为 0 时只受长度字段宽度限制
+	MaxSize int
+	// 为 true 时遇到损坏的数据跳到下一个魔数继续解析，否则返回 CorruptError
+	Resync bool
+}
+
+// V1 scanner.go 使用的格式：魔数 "V1" 后跟大端序 int16 长度
+var V1 = Codec{Magic: []byte("V1"), LengthSize: 2, Order: binary.BigEndian, MaxSize: 1<<15 - 1}
+
+func (c Codec) order() binary.ByteOrder {
+	if c.Order == nil {
+		return binary.BigEndian
+	}
+	return c.Order
+}
+
+// header 返回帧头的长度
+func (c Codec) header() int {
+	return len(c.Magic) + c.LengthSize
+}
+
+// limit 返回负载的最大长度，取 MaxSize 与长度字段能表示的范围中较小的一个，
+// 并保证整帧的长度不超过 int 的范围。2 字节长度按有符号数处理，与 scanner.go 的 int16 保持一致
+func (c Codec) limit() uint64 {
+	var top uint64
+	switch c.LengthSize {
+	case 1:
+		top = 1<<8 - 1
+	case 2:
+		top = 1<<15 - 1
+	case 4:
+		top = 1<<31 - 1
+	default:
+		top = 1<<63 - 1
+	}
+	if c.MaxSize > 0 && uint64(c.MaxSize) < top {
+		top = uint64(c.MaxSize)
+	}
+	if max := uint64(math.MaxInt - c.header()); max < top {
+		top = max
+	}
+	return top
+}
+
+func (c Codec) valid() error {
+	switch c.LengthSize {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("frame: invalid length size %d", c.LengthSize)
+	}
+	if c.Resync && len(c.Magic) == 0 {
+		return errors.New("frame: resync requires a magic")
+	}
+	return nil
+}
+
+// readLength 解码长度字段
+func (c Codec) readLength(b []byte) uint64 {
+	switch c.LengthSize {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(c.order().Uint16(b))
+	case 4:
+		return uint64(c.order().Uint32(b))
+	}
+	return c.order().Uint64(b)
+}
+
+// Append 把 payload 编码为一帧追加到 dst
+func (c Codec) Append(dst, payload []byte) ([]byte, error) {
+	if err := c.valid(); err != nil {
+		return dst, err
+	}
+	if uint64(len(payload)) > c.limit() {
+		return dst, fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(payload), c.limit())
+	}
+
+	dst = append(dst, c.Magic...)
+	var b [8]byte
+	switch n := uint64(len(payload)); c.LengthSize {
+	case 1:
+		b[0] = byte(n)
+	case 2:
+		c.order().PutUint16(b[:], uint16(n))
+	case 4:
+		c.order().PutUint32(b[:], uint32(n))
+	default:
+		c.order().PutUint64(b[:], n)
+	}
+	dst = append(dst, b[:c.LengthSize]...)
+	return append(dst, payload...), nil
+}
+
+// parse 从 data 的开头解析一帧。返回 need > 0 表示至少还需要 need 字节；
+// 返回 err 表示开头的数据已损坏。token 为负载，n 为整帧的长度
+func (c Codec) parse(data []byte) (n int, token []byte, need int, err error) {
+	h := c.header()
+	if len(data) < h {
+		// 已经到手的部分魔数不匹配时不必等待
+		if !bytes.HasPrefix(c.Magic, data[:min(len(data), len(c.Magic))]) {
+			return 0, nil, 0, ErrMagic
+		}
+		return 0, nil, h - len(data), nil
+	}
+	if !bytes.HasPrefix(data, c.Magic) {
+		return 0, nil, 0, ErrMagic
+	}
+
+	length := c.readLength(data[len(c.Magic):h])
+	if length > c.limit() {
+		return 0, nil, 0, ErrTooLarge
+	}
+	// 先以无符号数比较，length 不会在转换为 int 时溢出
+	if rest := uint64(len(data) - h); length > rest {
+		return 0, nil, int(length - rest), nil
+	}
+	total := h + int(length)
+	return total, data[h:total], 0, nil
+}
+
+// resync 返回 data 中第 1 个字节之后下一个可能是魔数开头的位置，没有时返回可以丢弃的字节数。
+// 末尾不足一个魔数的部分保留，以免魔数被拆在两次读取之间
+func (c Codec) resync(data []byte) int {
+	if i := bytes.Index(data[1:], c.Magic); i >= 0 {
+		return i + 1
+	}
+	keep := len(c.Magic) - 1
+	if keep > len(data)-1 {
+		keep = len(data) - 1
+	}
+	return len(data) - keep
+}
+
+// Splitter 维护偏移与跳过的字节数的 bufio.SplitFunc
+type Splitter struct {
+	codec   Codec
+	offset  int64
+	skipped int64
+}
+
+// NewSplitter 返回使用 c 解析帧的 Splitter，c 无效时返回错误
+func NewSplitter(c Codec) (*Splitter, error) {
+	if err := c.valid(); err != nil {
+		return nil, err
+	}
+	return &Splitter{codec: c}, nil
+}
+
+// Skipped 返回重新同步时跳过的字节数
+func (s *Splitter) Skipped() int64 {
+	return s.skipped
+}
+
+// Split 实现 bufio.SplitFunc，token 为一帧的负载。
+// 重新同步时在同一次调用中继续解析跳过之后的数据：输入结束后 bufio.Scanner
+// 把没有 token 的返回视为扫描结束，单独返回跳过的字节会丢掉后面的帧
+func (s *Splitter) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for len(data) > advance {
+		n, token, need, err := s.codec.parse(data[advance:])
+		switch {
+		case err != nil:
+			if !s.codec.Resync {
+				return advance, nil, &CorruptError{Offset: s.offset, Err: err}
+			}
+			skip := s.codec.resync(data[advance:])
+			s.offset += int64(skip)
+			s.skipped += int64(skip)
+			advance += skip
+		case need > 0:
+			if atEOF {
+				return advance, nil, &CorruptError{Offset: s.offset, Err: ErrTruncated}
+			}
+			return advance, nil, nil
+		default:
+			s.offset += int64(n)
+			return advance + n, token, nil
+		}
+	}
+	return advance, nil, nil
+}
+
+// maxBuffer Reader 的缓冲区上限，也是 Reader 能读取的最大帧长度
+const maxBuffer = 1 << 30
+
+// Reader 从 io.Reader 中逐帧读取
+type Reader struct {
+	scanner  *bufio.Scanner
+	splitter *Splitter
+}
+
+// NewReader 返回从 r 读取 c 格式帧的 Reader。
+// 负载长度的上限不超过 maxBuffer 减去帧头，更长的帧与超过 MaxSize 的帧一样返回 ErrTooLarge
+func NewReader(r io.Reader, c Codec) (*Reader, error) {
+	if err := c.valid(); err != nil {
+		return nil, err
+	}
+	if max := uint64(maxBuffer - c.header()); c.limit() > max {
+		c.MaxSize = int(max)
+	}
+
+	splitter, err := NewSplitter(c)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	size := c.header() + int(c.limit())
+	scanner.Buffer(make([]byte, 0, min(size, 64*1024)), size)
+	scanner.Split(splitter.Split)
+	return &Reader{scanner: scanner, splitter: splitter}, nil
+}
+
+// Read 返回下一帧的负载，只在下一次调用 Read 前有效；输入在帧边界结束时返回 io.EOF
+func (r *Reader) Read() ([]byte, error) {
+	if r.scanner.Scan() {
+		return r.scanner.Bytes(), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Skipped 返回重新同步时跳过的字节数
+func (r *Reader) Skipped() int64 {
+	return r.splitter.Skipped()
+}
+
+// Writer 向 io.Writer 逐帧写入
+type Writer struct {
+	w     io.Writer
+	codec Codec
+	buf   []byte
+}
+
+// NewWriter 返回向 w 写入 c 格式帧的 Writer
+func NewWriter(w io.Writer, c Codec) (*Writer, error) {
+	if err := c.valid(); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, codec: c}, nil
+}
+
+// Write 把 payload 编码为一帧，帧头与负载在一次 Write 中写出
+func (w *Writer) Write(payload []byte) error {
+	buf, err := w.codec.Append(w.buf[:0], payload)
+	if err != nil {
+		return err
+	}
+	w.buf = buf
+	_, err = w.w.Write(buf)
+	return err
+}
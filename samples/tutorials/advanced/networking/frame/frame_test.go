@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/11        Feng Yifei
+ */
+
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	payloads := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{'x'}, 200)}
+
+	for _, c := range []Codec{
+		V1,
+		{LengthSize: 1},
+		{Magic: []byte{0xca, 0xfe}, LengthSize: 4, Order: binary.LittleEndian},
+		{Magic: []byte("F"), LengthSize: 8, MaxSize: 1024},
+	} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range payloads {
+			if err := w.Write(p); err != nil {
+				t.Fatalf("%+v: %v", c, err)
+			}
+		}
+
+		// 所有帧粘在一起，每次只提供一个字节
+		r, err := NewReader(iotestOneByte{&buf}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range payloads {
+			got, err := r.Read()
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("%+v frame %d: %q, %v", c, i, got, err)
+			}
+		}
+		if _, err := r.Read(); err != io.EOF {
+			t.Fatalf("%+v: %v, want io.EOF", c, err)
+		}
+	}
+}
+
+// iotestOneByte 每次只读一个字节
+type iotestOneByte struct {
+	r io.Reader
+}
+
+func (o iotestOneByte) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestScannerV1(t *testing.T) {
+	// 与 scanner.go 原有格式一致：'V' '1' 后跟大端序 int16 长度
+	data := []byte("V1\x00\x03abcV1\x00\x02de")
+
+	s, _ := NewSplitter(V1)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(s.Split)
+
+	var got []string
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	if scanner.Err() != nil || strings.Join(got, ",") != "abc,de" {
+		t.Fatalf("got %q, %v", got, scanner.Err())
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	good, _ := V1.Append(nil, []byte("ok"))
+	data := append(append([]byte{}, good...), "garbageV"...)
+	data = append(data, good...)
+	data = append(data, "V1\x7f\xffxx"...) // 超过 MaxSize 的长度
+	data = append(data, good...)
+
+	r, _ := NewReader(bytes.NewReader(data), V1)
+	if p, err := r.Read(); err != nil || string(p) != "ok" {
+		t.Fatalf("first frame %q, %v", p, err)
+	}
+	_, err := r.Read()
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || !errors.Is(err, ErrMagic) || corrupt.Offset != int64(len(good)) {
+		t.Fatalf("error %v, want bad magic at offset %d", err, len(good))
+	}
+
+	limited := V1
+	limited.MaxSize = 100
+	limited.Resync = true
+	r, _ = NewReader(bytes.NewReader(data), limited)
+	n := 0
+	for {
+		p, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || string(p) != "ok" {
+			t.Fatalf("frame %d: %q, %v", n, p, err)
+		}
+		n++
+	}
+	if n != 3 || r.Skipped() != int64(len("garbageV")+len("V1\x7f\xffxx")) {
+		t.Fatalf("%d frames, skipped %d", n, r.Skipped())
+	}
+}
+
+func TestHugeLength(t *testing.T) {
+	// 8 字节长度字段的最大值，转换为 int 后加上帧头会溢出
+	c := Codec{Magic: []byte("M"), LengthSize: 8}
+	data := []byte("M\x7f\xff\xff\xff\xff\xff\xff\xffxx")
+
+	s, _ := NewSplitter(c)
+	if _, _, err := s.Split(data, true); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("error %v, want ErrTooLarge", err)
+	}
+
+	// limit 以内的长度同样不会溢出，只是需要更多数据
+	binary.BigEndian.PutUint64(data[1:], c.limit())
+	if _, _, need, err := c.parse(data); err != nil || need <= 0 {
+		t.Fatalf("need %d, %v", need, err)
+	}
+
+	// 超过 Reader 缓冲区的帧返回 ErrTooLarge，而不是 bufio.ErrTooLong
+	for _, c := range []Codec{c, {Magic: []byte("M"), LengthSize: 4}} {
+		frame := make([]byte, c.header())
+		copy(frame, c.Magic)
+		switch c.LengthSize {
+		case 4:
+			binary.BigEndian.PutUint32(frame[1:], maxBuffer)
+		case 8:
+			binary.BigEndian.PutUint64(frame[1:], maxBuffer)
+		}
+		good, _ := c.Append(nil, []byte("ok"))
+
+		r, _ := NewReader(bytes.NewReader(append(frame, good...)), c)
+		_, err := r.Read()
+		var corrupt *CorruptError
+		if !errors.As(err, &corrupt) || !errors.Is(err, ErrTooLarge) || corrupt.Offset != 0 {
+			t.Fatalf("length size %d: error %v, want ErrTooLarge at offset 0", c.LengthSize, err)
+		}
+
+		c.Resync = true
+		r, _ = NewReader(bytes.NewReader(append(frame, good...)), c)
+		if p, err := r.Read(); err != nil || string(p) != "ok" {
+			t.Fatalf("length size %d: resync %q, %v", c.LengthSize, p, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	r, _ := NewReader(strings.NewReader("V1\x00\x05ab"), V1)
+	if _, err := r.Read(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("error %v, want ErrTruncated", err)
+	}
+
+	if _, err := (Codec{LengthSize: 1}).Append(nil, make([]byte, 256)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("error %v, want ErrTooLarge", err)
+	}
+	if _, err := NewWriter(io.Discard, Codec{LengthSize: 3}); err == nil {
+		t.Fatal("length size 3 accepted")
+	}
+	if _, err := NewSplitter(Codec{LengthSize: 2, Resync: true}); err == nil {
+		t.Fatal("resync without magic accepted")
+	}
+}
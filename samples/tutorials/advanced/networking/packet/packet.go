@@ -0,0 +1,244 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/12        Feng Yifei
+ */
+
+// Package packet 是 scanner.go 粘包示例中的数据包格式，
+// 参考 https://www.ddhigh.com/2018/03/02/golang-tcp-stick-package.html
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 数据部分中 Timestamp、HostnameLength、TagLength 三个定长字段的长度
+const fixedLength = 8 + 2 + 2
+
+// maxLength 数据部分的最大长度，Length 为 int16
+const maxLength = 1<<15 - 1
+
+var (
+	// ErrNegativeLength 长度字段为负数
+	ErrNegativeLength = errors.New("packet: negative length")
+	// ErrTooLong 长度超过数据部分剩余的字节数，或超过 maxLength
+	ErrTooLong = errors.New("packet: length too long")
+	// ErrTooShort Length 小于定长字段所需的 fixedLength 字节
+	ErrTooShort = errors.New("packet: length too short")
+	// ErrVersion 不支持的协议版本
+	ErrVersion = errors.New("packet: unknown version")
+)
+
+// V1 目前唯一支持的协议版本
+var V1 = [2]byte{'V', '1'}
+
+// FieldError 编解码某个字段失败，Offset 为该字段相对数据包起点的字节偏移
+type FieldError struct {
+	Field  string
+	Offset int
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("package field %s at offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Package 数据包：Version 与 Length 之后是 Length 字节的数据部分，Version 只能为 V1
+type Package struct {
+	Version        [2]byte // 协议版本
+	Length         int16   // 数据部分长度，Pack 时根据其余字段计算
+	Timestamp      int64   // 时间戳
+	HostnameLength int16   // 主机名长度，Pack 时根据 Hostname 计算
+	Hostname       []byte  // 主机名
+	TagLength      int16   // Tag长度，Pack 时根据 Tag 计算
+	Tag            []byte  // Tag
+	Msg            []byte  // 数据部分长度
+}
+
+// packer 按顺序写入字段，记录偏移，遇到第一个错误后不再写入
+type packer struct {
+	w      io.Writer
+	offset int
+	err    error
+}
+
+func (w *packer) write(field string, v interface{}) {
+	if w.err != nil {
+		return
+	}
+	if err := binary.Write(w.w, binary.BigEndian, v); err != nil {
+		w.err = &FieldError{Field: field, Offset: w.offset, Err: err}
+		return
+	}
+	w.offset += binary.Size(v)
+}
+
+// Pack 根据 Hostname、Tag、Msg 计算 HostnameLength、TagLength 与 Length 后写入 writer，
+// 数据部分超过 maxLength 时返回错误，不写入任何数据
+func (p *Package) Pack(writer io.Writer) error {
+	length := fixedLength + len(p.Hostname) + len(p.Tag) + len(p.Msg)
+	if length > maxLength {
+		return &FieldError{Field: "length", Offset: 2, Err: fmt.Errorf("%w: %d bytes, at most %d", ErrTooLong, length, maxLength)}
+	}
+	if p.Version != V1 {
+		return &FieldError{Field: "version", Offset: 0, Err: fmt.Errorf("%w: %q", ErrVersion, p.Version[:])}
+	}
+	p.HostnameLength = int16(len(p.Hostname))
+	p.TagLength = int16(len(p.Tag))
+	p.Length = int16(length)
+
+	w := &packer{w: writer}
+	w.write("version", &p.Version)
+	w.write("length", p.Length)
+	w.write("timestamp", p.Timestamp)
+	w.write("hostname_length", p.HostnameLength)
+	w.write("hostname", p.Hostname)
+	w.write("tag_length", p.TagLength)
+	w.write("tag", p.Tag)
+	w.write("msg", p.Msg)
+	return w.err
+}
+
+// unpacker 按顺序读取字段，记录偏移与数据部分剩余的字节数
+type unpacker struct {
+	r      io.Reader
+	offset int
+	remain int // 数据部分尚未读取的字节数
+}
+
+func (r *unpacker) read(field string, v interface{}) error {
+	if err := binary.Read(r.r, binary.BigEndian, v); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return &FieldError{Field: field, Offset: r.offset, Err: err}
+	}
+	r.offset += binary.Size(v)
+	r.remain -= binary.Size(v)
+	return nil
+}
+
+// bytes 读取长度为 n 的字段，其后还有 reserve 字节的定长字段。
+// n 为负数或超过数据部分剩余的字节数时不分配内存，直接返回错误
+func (r *unpacker) bytes(field string, n, reserve int) ([]byte, error) {
+	switch left := r.remain - reserve; {
+	case n < 0:
+		return nil, &FieldError{Field: field, Offset: r.offset, Err: fmt.Errorf("%w: %d", ErrNegativeLength, n)}
+	case n > left:
+		return nil, &FieldError{Field: field, Offset: r.offset, Err: fmt.Errorf("%w: %d bytes, %d left", ErrTooLong, n, left)}
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &FieldError{Field: field, Offset: r.offset, Err: err}
+	}
+	r.offset += n
+	r.remain -= n
+	return b, nil
+}
+
+// Unpack 从 reader 读取一个完整的数据包，包括版本与长度
+func (p *Package) Unpack(reader io.Reader) error {
+	r := &unpacker{r: reader}
+	if err := r.read("version", &p.Version); err != nil {
+		return err
+	}
+	if p.Version != V1 {
+		return &FieldError{Field: "version", Offset: 0, Err: fmt.Errorf("%w: %q", ErrVersion, p.Version[:])}
+	}
+	if err := r.read("length", &p.Length); err != nil {
+		return err
+	}
+	return p.unpackData(r)
+}
+
+// UnpackData 解析版本为 version 的数据部分，用于 frame 已经去掉版本与长度的场景
+func (p *Package) UnpackData(version [2]byte, data []byte) error {
+	switch {
+	case version != V1:
+		return &FieldError{Field: "version", Offset: 0, Err: fmt.Errorf("%w: %q", ErrVersion, version[:])}
+	case len(data) > maxLength:
+		return &FieldError{Field: "length", Offset: 2, Err: fmt.Errorf("%w: %d bytes, at most %d", ErrTooLong, len(data), maxLength)}
+	case len(data) < fixedLength:
+		return &FieldError{Field: "length", Offset: 2, Err: fmt.Errorf("%w: %d bytes, at least %d", ErrTooShort, len(data), fixedLength)}
+	}
+	p.Version = version
+	p.Length = int16(len(data))
+	return p.unpackData(&unpacker{r: bytes.NewReader(data), offset: 4})
+}
+
+// unpackData 从 Length 之后开始解析，每个变长字段都不能超过数据部分剩余的字节数
+func (p *Package) unpackData(r *unpacker) error {
+	switch {
+	case p.Length < 0:
+		return &FieldError{Field: "length", Offset: 2, Err: fmt.Errorf("%w: %d", ErrNegativeLength, p.Length)}
+	case p.Length < fixedLength:
+		return &FieldError{Field: "length", Offset: 2, Err: fmt.Errorf("%w: %d bytes, at least %d", ErrTooShort, p.Length, fixedLength)}
+	}
+	r.remain = int(p.Length)
+
+	var err error
+	if err = r.read("timestamp", &p.Timestamp); err != nil {
+		return err
+	}
+	if err = r.read("hostname_length", &p.HostnameLength); err != nil {
+		return err
+	}
+	// 主机名之后还有 2 字节的 TagLength
+	if p.Hostname, err = r.bytes("hostname", int(p.HostnameLength), 2); err != nil {
+		return err
+	}
+	if err = r.read("tag_length", &p.TagLength); err != nil {
+		return err
+	}
+	if p.Tag, err = r.bytes("tag", int(p.TagLength), 0); err != nil {
+		return err
+	}
+	p.Msg, err = r.bytes("msg", r.remain, 0)
+	return err
+}
+
+func (p *Package) String() string {
+	return fmt.Sprintf("version:%s length:%d timestamp:%d hostname:%s tag:%s msg:%s",
+		p.Version,
+		p.Length,
+		p.Timestamp,
+		p.Hostname,
+		p.Tag,
+		p.Msg,
+	)
+}
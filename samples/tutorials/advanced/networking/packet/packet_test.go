@@ -0,0 +1,177 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2018 TechCatsLab
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+/*
+ * Revision History:
+ *     Initial: 2018/08/12        Feng Yifei
+ */
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// encode 按字段原样写出 V1 数据包，长度字段不做任何校验
+func encode(length int16, hostnameLength int16, hostname string, tagLength int16, tag, msg string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("V1")
+	binary.Write(&buf, binary.BigEndian, length)
+	binary.Write(&buf, binary.BigEndian, int64(1533945600))
+	binary.Write(&buf, binary.BigEndian, hostnameLength)
+	buf.WriteString(hostname)
+	binary.Write(&buf, binary.BigEndian, tagLength)
+	buf.WriteString(tag)
+	buf.WriteString(msg)
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	p := &Package{Version: [2]byte{'V', '1'}, Timestamp: 1533945600, Hostname: []byte("ab"), Tag: []byte("xy"), Msg: []byte("hi")}
+
+	var buf bytes.Buffer
+	if err := p.Pack(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if p.Length != 18 || p.HostnameLength != 2 || p.TagLength != 2 {
+		t.Fatalf("lengths %d %d %d, want 18 2 2", p.Length, p.HostnameLength, p.TagLength)
+	}
+	if want := encode(18, 2, "ab", 2, "xy", "hi"); !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("packed %x, want %x", buf.Bytes(), want)
+	}
+
+	var got Package
+	if err := got.Unpack(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != p.String() {
+		t.Fatalf("unpacked %s, want %s", &got, p)
+	}
+
+	var data Package
+	if err := data.UnpackData(p.Version, buf.Bytes()[4:]); err != nil || data.String() != p.String() {
+		t.Fatalf("UnpackData %s, %v", &data, err)
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	valid := encode(18, 2, "ab", 2, "xy", "hi")
+
+	for _, c := range []struct {
+		name   string
+		data   []byte
+		field  string
+		offset int
+		err    error
+	}{
+		{"negative length", encode(-5, 0, "", 0, "", ""), "length", 2, ErrNegativeLength},
+		{"unknown version", append([]byte("V2"), encode(18, 2, "ab", 2, "xy", "hi")[2:]...), "version", 0, ErrVersion},
+		{"length below fixed fields", encode(11, 0, "", 0, "", ""), "length", 2, ErrTooShort},
+		{"zero length", encode(0, 0, "", 0, "", ""), "length", 2, ErrTooShort},
+		{"negative hostname length", encode(12, -1, "", 0, "", ""), "hostname", 14, ErrNegativeLength},
+		{"negative tag length", encode(14, 2, "ab", -3, "", ""), "tag", 18, ErrNegativeLength},
+		{"hostname past length", encode(12, 1, "", 0, "", ""), "hostname", 14, ErrTooLong},
+		{"hostname into tag length", encode(14, 3, "ab", 0, "", ""), "hostname", 14, ErrTooLong},
+		{"tag past length", encode(14, 2, "ab", 5, "", ""), "tag", 18, ErrTooLong},
+		{"empty", nil, "version", 0, io.ErrUnexpectedEOF},
+		{"truncated version", valid[:1], "version", 0, io.ErrUnexpectedEOF},
+		{"truncated length", valid[:3], "length", 2, io.ErrUnexpectedEOF},
+		{"truncated timestamp", valid[:6], "timestamp", 4, io.ErrUnexpectedEOF},
+		{"truncated hostname length", valid[:13], "hostname_length", 12, io.ErrUnexpectedEOF},
+		{"truncated hostname", valid[:15], "hostname", 14, io.ErrUnexpectedEOF},
+		{"truncated tag length", valid[:17], "tag_length", 16, io.ErrUnexpectedEOF},
+		{"truncated tag", valid[:19], "tag", 18, io.ErrUnexpectedEOF},
+		{"truncated msg", valid[:21], "msg", 20, io.ErrUnexpectedEOF},
+	} {
+		var p Package
+		err := p.Unpack(bytes.NewReader(c.data))
+
+		var fe *FieldError
+		if !errors.As(err, &fe) || fe.Field != c.field || fe.Offset != c.offset || !errors.Is(err, c.err) {
+			t.Errorf("%s: error %v, want %v in %s at offset %d", c.name, err, c.err, c.field, c.offset)
+		}
+	}
+}
+
+func TestUnpackDataErrors(t *testing.T) {
+	version := V1
+
+	for _, c := range []struct {
+		name   string
+		data   []byte
+		field  string
+		offset int
+		err    error
+	}{
+		{"too long", make([]byte, maxLength+1), "length", 2, ErrTooLong},
+		{"below fixed fields", make([]byte, fixedLength-1), "length", 2, ErrTooShort},
+		{"empty", nil, "length", 2, ErrTooShort},
+		{"negative hostname length", encode(12, -1, "", 0, "", "")[4:], "hostname", 14, ErrNegativeLength},
+		{"tag past data", encode(14, 2, "ab", 1, "", "")[4:], "tag", 18, ErrTooLong},
+	} {
+		var p Package
+		err := p.UnpackData(version, c.data)
+
+		var fe *FieldError
+		if !errors.As(err, &fe) || fe.Field != c.field || fe.Offset != c.offset || !errors.Is(err, c.err) {
+			t.Errorf("%s: error %v, want %v in %s at offset %d", c.name, err, c.err, c.field, c.offset)
+		}
+	}
+
+	var p Package
+	err := p.UnpackData([2]byte{'V', '2'}, encode(18, 2, "ab", 2, "xy", "hi")[4:])
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Field != "version" || fe.Offset != 0 || !errors.Is(err, ErrVersion) {
+		t.Fatalf("error %v, want ErrVersion in version", err)
+	}
+}
+
+func TestPackErrors(t *testing.T) {
+	p := &Package{Version: V1, Msg: make([]byte, maxLength-fixedLength+1)}
+
+	var buf bytes.Buffer
+	err := p.Pack(&buf)
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Field != "length" || !errors.Is(err, ErrTooLong) {
+		t.Fatalf("error %v, want ErrTooLong in length", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes written", buf.Len())
+	}
+
+	p.Msg = p.Msg[1:]
+	if err := p.Pack(&buf); err != nil || p.Length != maxLength {
+		t.Fatalf("length %d, %v", p.Length, err)
+	}
+
+	buf.Reset()
+	p.Version = [2]byte{'V', '2'}
+	if err := p.Pack(&buf); !errors.As(err, &fe) || fe.Field != "version" || !errors.Is(err, ErrVersion) || buf.Len() != 0 {
+		t.Fatalf("error %v, %d bytes written, want ErrVersion in version", err, buf.Len())
+	}
+}
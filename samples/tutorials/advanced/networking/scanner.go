@@ -5,106 +5,55 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
-	"fmt"
-	"io"
 	"log"
 	"os"
 	"time"
-)
-
-type Package struct {
-	Version        [2]byte // 协议版本
-	Length         int16   // 数据部分长度
-	Timestamp      int64   // 时间戳
-	HostnameLength int16   // 主机名长度
-	Hostname       []byte  // 主机名
-	TagLength      int16   // Tag长度
-	Tag            []byte  // Tag
-	Msg            []byte  // 数据部分长度
-}
-
-func (p *Package) Pack(writer io.Writer) error {
-	var err error
-	err = binary.Write(writer, binary.BigEndian, &p.Version)
-	err = binary.Write(writer, binary.BigEndian, &p.Length)
-	err = binary.Write(writer, binary.BigEndian, &p.Timestamp)
-	err = binary.Write(writer, binary.BigEndian, &p.HostnameLength)
-	err = binary.Write(writer, binary.BigEndian, &p.Hostname)
-	err = binary.Write(writer, binary.BigEndian, &p.TagLength)
-	err = binary.Write(writer, binary.BigEndian, &p.Tag)
-	err = binary.Write(writer, binary.BigEndian, &p.Msg)
-	return err
-}
 
-func (p *Package) Unpack(reader io.Reader) error {
-	var err error
-	err = binary.Read(reader, binary.BigEndian, &p.Version)
-	err = binary.Read(reader, binary.BigEndian, &p.Length)
-	err = binary.Read(reader, binary.BigEndian, &p.Timestamp)
-	err = binary.Read(reader, binary.BigEndian, &p.HostnameLength)
-	p.Hostname = make([]byte, p.HostnameLength)
-	err = binary.Read(reader, binary.BigEndian, &p.Hostname)
-	err = binary.Read(reader, binary.BigEndian, &p.TagLength)
-	p.Tag = make([]byte, p.TagLength)
-	err = binary.Read(reader, binary.BigEndian, &p.Tag)
-	p.Msg = make([]byte, p.Length-8-2-p.HostnameLength-2-p.TagLength)
-	err = binary.Read(reader, binary.BigEndian, &p.Msg)
-	return err
-}
-
-func (p *Package) String() string {
-	return fmt.Sprintf("version:%s length:%d timestamp:%d hostname:%s tag:%s msg:%s",
-		p.Version,
-		p.Length,
-		p.Timestamp,
-		p.Hostname,
-		p.Tag,
-		p.Msg,
-	)
-}
+	"github.com/TechCatsLab/gosnippet/samples/tutorials/advanced/networking/frame"
+	"github.com/TechCatsLab/gosnippet/samples/tutorials/advanced/networking/packet"
+)
 
 func main() {
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatal(err)
 	}
-	pack := &Package{
-		Version:        [2]byte{'V', '1'},
-		Timestamp:      time.Now().Unix(),
-		HostnameLength: int16(len(hostname)),
-		Hostname:       []byte(hostname),
-		TagLength:      4,
-		Tag:            []byte("demo"),
-		Msg:            []byte(("现在时间是:" + time.Now().Format("2006-01-02 15:04:05"))),
+	pack := &packet.Package{
+		Version:   packet.V1,
+		Timestamp: time.Now().Unix(),
+		Hostname:  []byte(hostname),
+		Tag:       []byte("demo"),
+		Msg:       []byte(("现在时间是:" + time.Now().Format("2006-01-02 15:04:05"))),
 	}
-	pack.Length = 8 + 2 + pack.HostnameLength + 2 + pack.TagLength + int16(len(pack.Msg))
 	buf := new(bytes.Buffer)
-	// 写入四次，模拟TCP粘包效果
-	pack.Pack(buf)
-	pack.Pack(buf)
-	pack.Pack(buf)
-	pack.Pack(buf)
-	// scanner
-	scanner := bufio.NewScanner(buf)
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if !atEOF && data[0] == 'V' {
-			if len(data) > 4 {
-				length := int16(0)
-				binary.Read(bytes.NewReader(data[2:4]), binary.BigEndian, &length)
-				if int(length)+4 <= len(data) {
-					return int(length) + 4, data[:int(length)+4], nil
-				}
-			}
+	// 写入四次，模拟TCP粘包效果；第三次之前混入一段损坏的数据
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			buf.WriteString("garbage")
+		}
+		if err := pack.Pack(buf); err != nil {
+			log.Fatal(err)
 		}
-		return
-	})
+	}
+	// scanner，frame.V1 与 Version 加 Length 的格式一致，遇到损坏的数据时跳到下一个 "V1"
+	codec := frame.V1
+	codec.Resync = true
+	splitter, err := frame.NewSplitter(codec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scanner := bufio.NewScanner(buf)
+	scanner.Split(splitter.Split)
 	for scanner.Scan() {
-		scannedPack := new(Package)
-		scannedPack.Unpack(bytes.NewReader(scanner.Bytes()))
+		scannedPack := new(packet.Package)
+		if err := scannedPack.UnpackData(pack.Version, scanner.Bytes()); err != nil {
+			log.Println("无效数据包:", err)
+			continue
+		}
 		log.Println(scannedPack)
 	}
 	if err := scanner.Err(); err != nil {
-		log.Fatal("无效数据包")
+		log.Fatal("无效数据包: ", err)
 	}
+	log.Println("跳过", splitter.Skipped(), "字节")
 }